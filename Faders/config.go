@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"rawpanel/config"
+)
+
+// Config holds the panel address, web server address and fader mapping.
+type Config struct {
+	Panel  string `json:"panel"`  // Raw Panel server, host:port
+	Listen string `json:"listen"` // Web page and WebSocket server, e.g. ":8080"
+
+	Faders FaderConfig `json:"faders"`
+}
+
+// FaderConfig maps the faders of the panel.
+type FaderConfig struct {
+	Red       int `json:"red"`
+	Green     int `json:"green"`
+	Blue      int `json:"blue"`
+	Intensity int `json:"intensity"`
+
+	// Motorized is the fader that follows the browser; usually one of the above
+	Motorized int `json:"motorized"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Panel:  "192.168.11.155:9923",
+		Listen: ":8080",
+		Faders: FaderConfig{
+			Red:       9,
+			Green:     10,
+			Blue:      11,
+			Intensity: 12,
+			Motorized: 9,
+		},
+	}
+}
+
+// loadConfig reads the config file given by -config or FADERS_CONFIG, then
+// applies environment variables and flags on top.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("Faders", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("FADERS_CONFIG"), "JSON config file")
+	panel := fs.String("panel", "", "Raw Panel server address, host:port (env FADERS_PANEL)")
+	listen := fs.String("listen", "", "web server address (env FADERS_LISTEN)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := config.Load(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	config.Override(&cfg.Panel, os.Getenv("FADERS_PANEL"), *panel)
+	config.Override(&cfg.Listen, os.Getenv("FADERS_LISTEN"), *listen)
+
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+	errs = append(errs,
+		config.CheckAddr("panel", cfg.Panel),
+		config.CheckHWCs(cfg.hwcs()),
+	)
+	if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	}
+	if cfg.Faders.Motorized < 1 {
+		errs = append(errs, fmt.Errorf("faders.motorized: invalid HWC ID %d", cfg.Faders.Motorized))
+	}
+	return errors.Join(errs...)
+}
+
+// hwcs returns the fader HWC IDs by setting name. The motorized fader is
+// left out as it normally doubles as one of the others.
+func (cfg *Config) hwcs() map[string]int {
+	return map[string]int{
+		"faders.red":       cfg.Faders.Red,
+		"faders.green":     cfg.Faders.Green,
+		"faders.blue":      cfg.Faders.Blue,
+		"faders.intensity": cfg.Faders.Intensity,
+	}
+}
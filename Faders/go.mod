@@ -5,3 +5,7 @@ go 1.21.0
 require github.com/gorilla/websocket v1.5.1
 
 require golang.org/x/net v0.17.0 // indirect
+
+require rawpanel v0.0.0
+
+replace rawpanel => ../rawpanel
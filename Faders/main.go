@@ -3,13 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"net"
 	"net/http"
-	"strconv"
-	"strings"
+	"os"
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"rawpanel"
 )
 
 var (
@@ -17,14 +16,40 @@ var (
 	faderRGB      [3]int // Values for R, G, and B faders (0-1000)
 	intensity     int    // Value for overall intensity fader (0-1000)
 	websocketPool = make(map[*websocket.Conn]struct{})
+	rawPanelConn  *rawpanel.Client
+	cfg           *Config
 )
 
 func main() {
+	// Load the configuration from file, environment and flags
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println("Error loading configuration:", err)
+		os.Exit(2)
+	}
+
 	// Initialize the fader values
 	faderRGB = [3]int{0, 0, 0}
 	intensity = 0
 
-	// Start a TCP client to connect to the Raw Panel
+	// Connect to the Raw Panel, reconnecting whenever it goes away
+	rawPanelConn = rawpanel.Connect(cfg.Panel, rawpanel.Options{
+		OnStateChange: func(state rawpanel.ConnState) {
+			fmt.Println("Raw Panel", state)
+		},
+		OnTopology: func(topology *rawpanel.Topology) {
+			if err := topology.CheckHWCs(cfg.hwcs()); err != nil {
+				fmt.Println("Warning:", err)
+			}
+		},
+		OnError: func(err error) {
+			fmt.Println("Skipping malformed event:", err)
+		},
+	})
+	defer rawPanelConn.Close()
+
+	// Start processing fader inputs from the Raw Panel
 	go startTCPClient()
 
 	// Create a WebSocket server to communicate with the browser
@@ -73,74 +98,24 @@ func main() {
 		fmt.Fprint(w, html)
 	})
 
-	http.ListenAndServe(":8080", nil)
-}
-
-func startTCPClient() {
-	serverAddr := "192.168.11.155:9923"
-
-	conn, err := net.Dial("tcp", serverAddr)
+	err = http.ListenAndServe(cfg.Listen, nil)
 	if err != nil {
-		fmt.Println("Failed to connect to the server:", err)
-		return
-	}
-	defer conn.Close()
-
-	// Send the initialization command to the server
-	conn.Write([]byte("list\n"))
-
-	// Read and process fader position inputs
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			fmt.Println("Error reading from server:", err)
-			return
-		}
-
-		data := string(buf[:n])
-		processFaderInput(data)
+		fmt.Println("Error serving HTTP:", err)
 	}
 }
 
-func processFaderInput(data string) {
-	// Split the input into lines
-	lines := strings.Split(data, "\n")
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "HWC#") {
-			// Parse fader position input
-			parts := strings.Split(line, "=")
-			if len(parts) == 2 {
-				faderID := strings.TrimPrefix(parts[0], "HWC#")
-				position := strings.TrimPrefix(parts[1], "Abs:")
-
-				// Convert faderID and position to integers
-				faderNum := parseFaderID(faderID)
-				faderPos := parseFaderPosition(position)
-
-				//fmt.Println(faderNum, faderPos)
+func startTCPClient() {
+	// Read and process fader position inputs
+	for ev := range rawPanelConn.Events() {
+		if ev.Trigger == rawpanel.TriggerAbs {
+			//fmt.Println(ev.HWC, ev.Value)
 
-				// Update fader values
-				updateFaderValue(faderNum, faderPos)
-			}
+			// Update fader values
+			updateFaderValue(ev.HWC, ev.Value)
 		}
 	}
 }
 
-func parseFaderID(faderID string) int {
-	// Parse fader number from faderID (e.g., "10" from "HWC#10")
-	num := strings.TrimPrefix(faderID, "#")
-	faderNum, _ := strconv.Atoi(num)
-	return faderNum
-}
-
-func parseFaderPosition(position string) int {
-	// Parse fader position (0-1000) from the input
-	pos, _ := strconv.Atoi(position)
-	return pos
-}
-
 func updateFaderValue(faderNum, faderPos int) {
 	// Update the corresponding fader value (R, G, B, or intensity)
 	// based on faderNum and faderPos
@@ -148,13 +123,13 @@ func updateFaderValue(faderNum, faderPos int) {
 	defer faderMutex.Unlock()
 
 	switch faderNum {
-	case 9:
+	case cfg.Faders.Red:
 		faderRGB[0] = scaleToRGB(faderPos)
-	case 10:
+	case cfg.Faders.Green:
 		faderRGB[1] = scaleToRGB(faderPos)
-	case 11:
+	case cfg.Faders.Blue:
 		faderRGB[2] = scaleToRGB(faderPos)
-	case 12:
+	case cfg.Faders.Intensity:
 		intensity = faderPos
 	}
 
@@ -236,25 +211,16 @@ func adjustFirstFaderPosition(r int) {
 	// Scale the received RGB value (0-255) to the range 0-1000
 	position := (r * 1000) / 255
 
-	// Construct the command to set the position of the first fader (e.g., fader 9)
-	command := fmt.Sprintf(`{"HWCIDs":[9],"HWCExtended":{"Interpretation":5,"Value":%d}}`, position) + "\n"
+	// Construct the command to set the position of the motorized fader
+	command := rawpanel.NewState(cfg.Faders.Motorized).Extended(5, position)
 
 	// Send the command to the Raw Panel over the TCP connection
 	sendCommandToRawPanel(command)
 }
 
-func sendCommandToRawPanel(command string) {
-	// Create a TCP connection to the Raw Panel
-	rawPanelAddr := "192.168.11.155:9923"
-	conn, err := net.Dial("tcp", rawPanelAddr)
-	if err != nil {
-		fmt.Println("Failed to connect to the Raw Panel:", err)
-		return
-	}
-	defer conn.Close()
-
+func sendCommandToRawPanel(command *rawpanel.State) {
 	// Send the command to the Raw Panel
-	_, err = conn.Write([]byte(command))
+	err := rawPanelConn.Send(command)
 	if err != nil {
 		fmt.Println("Error sending command to Raw Panel:", err)
 	}
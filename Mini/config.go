@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"rawpanel/config"
+)
+
+// Config holds the panel address and the HWC IDs Mini reacts to.
+type Config struct {
+	Panel string    `json:"panel"` // Raw Panel server, host:port
+	HWC   HWCConfig `json:"hwc"`
+}
+
+// HWCConfig maps the controls of the panel.
+type HWCConfig struct {
+	RedEncoder   int `json:"redEncoder"`
+	GreenEncoder int `json:"greenEncoder"`
+	BlueEncoder  int `json:"blueEncoder"`
+	Fader        int `json:"fader"`
+	FaderDisplay int `json:"faderDisplay"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Panel: "192.168.11.194:9923",
+		HWC: HWCConfig{
+			RedEncoder:   4,
+			GreenEncoder: 5,
+			BlueEncoder:  6,
+			Fader:        20,
+			FaderDisplay: 24,
+		},
+	}
+}
+
+// loadConfig reads the config file given by -config or MINI_CONFIG, then
+// applies environment variables and flags on top.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("Mini", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("MINI_CONFIG"), "JSON config file")
+	panel := fs.String("panel", "", "Raw Panel server address, host:port (env MINI_PANEL)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := config.Load(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	config.Override(&cfg.Panel, os.Getenv("MINI_PANEL"), *panel)
+
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	return errors.Join(
+		config.CheckAddr("panel", cfg.Panel),
+		config.CheckHWCs(cfg.hwcs()),
+	)
+}
+
+// hwcs returns the configured HWC IDs by setting name.
+func (cfg *Config) hwcs() map[string]int {
+	return map[string]int{
+		"hwc.redEncoder":   cfg.HWC.RedEncoder,
+		"hwc.greenEncoder": cfg.HWC.GreenEncoder,
+		"hwc.blueEncoder":  cfg.HWC.BlueEncoder,
+		"hwc.fader":        cfg.HWC.Fader,
+		"hwc.faderDisplay": cfg.HWC.FaderDisplay,
+	}
+}
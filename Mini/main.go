@@ -1,81 +1,80 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"net"
-	"strings"
+	"os"
+
+	"rawpanel"
 )
 
 // Define variables for Red, Green, and Blue gains
 var RedGain, GreenGain, BlueGain int = 0, 0, 0
 var luminanceControl int = 1000
 
+var cfg *Config
+
 func main() {
-	// Connect to the Raw Panel server
-	conn, err := net.Dial("tcp", "192.168.11.194:9923")
+	// Load the configuration from file, environment and flags
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error connecting to server:", err)
-		return
+		fmt.Println("Error loading configuration:", err)
+		os.Exit(2)
 	}
+
+	// Connect to the Raw Panel server, reconnecting whenever the panel goes away
+	conn := rawpanel.Connect(cfg.Panel, rawpanel.Options{
+		OnStateChange: logConnState,
+		OnTopology:    checkTopology,
+		OnError:       logError,
+	})
 	defer conn.Close()
 
-	// Initialize the connection by sending "list\n"
-	_, err = conn.Write([]byte("list\n"))
-	if err != nil {
-		fmt.Println("Error sending initialization command:", err)
-		return
-	}
+	// Process incoming HWC events
+	for ev := range conn.Events() {
+		fmt.Println("Received:", ev.Line)
 
-	// Create a scanner to read incoming messages
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		// Process incoming commands
-		command := scanner.Text()
-		fmt.Println("Received:", command)
-
-		if strings.HasPrefix(command, "HWC#20=Abs:") {
-			// Parse fader value from the command
-			value := command[11:]
-			processFader(conn, value)
-		} else if strings.HasPrefix(command, "HWC#") {
-			// Parse encoder and value from the command
-			parts := strings.Split(command, "=")
-			if len(parts) == 2 {
-				encoder := parts[0][4:] // Extract the encoder number
-				value := parts[1]
-				processEncoder(conn, encoder, value)
-			}
+		if ev.HWC == cfg.HWC.Fader && ev.Trigger == rawpanel.TriggerAbs {
+			processFader(conn, ev.Value)
+		} else if ev.Trigger == rawpanel.TriggerEnc {
+			processEncoder(conn, ev.HWC, ev.Value)
 		}
 	}
 }
 
-func processEncoder(conn net.Conn, encoder, value string) {
-	// Convert the value to an integer
-	pulses := 0
-	_, err := fmt.Sscanf(value, "Enc:%d", &pulses)
-	if err != nil {
-		fmt.Println("Error parsing encoder value:", err)
-		return
+func logConnState(state rawpanel.ConnState) {
+	fmt.Println("Raw Panel server", state)
+}
+
+func logError(err error) {
+	fmt.Println("Skipping malformed event:", err)
+}
+
+func checkTopology(topology *rawpanel.Topology) {
+	fmt.Println("Connected to panel", topology.Model, topology.Serial)
+	if err := topology.CheckHWCs(cfg.hwcs()); err != nil {
+		fmt.Println("Warning:", err)
 	}
+}
 
+func processEncoder(conn *rawpanel.Client, encoder, pulses int) {
 	// Update RedGain, GreenGain, and BlueGain based on the encoder
 	// You can add error checking or bounds checking here
 	var displayValue int
 	switch encoder {
-	case "4":
+	case cfg.HWC.RedEncoder:
 		RedGain += pulses
 		if RedGain < 0 {
 			RedGain = 0
 		}
 		displayValue = RedGain
-	case "5":
+	case cfg.HWC.GreenEncoder:
 		GreenGain += pulses
 		if GreenGain < 0 {
 			GreenGain = 0
 		}
 		displayValue = GreenGain
-	case "6":
+	case cfg.HWC.BlueEncoder:
 		BlueGain += pulses
 		if BlueGain < 0 {
 			BlueGain = 0
@@ -87,29 +86,24 @@ func processEncoder(conn net.Conn, encoder, value string) {
 	fmt.Println("RedGain:", RedGain, "GreenGain:", GreenGain, "BlueGain:", BlueGain)
 
 	// Send a command to set the value in the display
-	displayCommand := fmt.Sprintf(`{"HWCIDs":[%s],"HWCText":{"Formatting":7,"Title":"Luminance","Textline1":"%d"}}`, encoder, displayValue) + "\n"
-	_, err = conn.Write([]byte(displayCommand))
+	displayCommand := rawpanel.NewState(encoder).
+		Text(rawpanel.HWCText{Formatting: 7, Title: "Luminance", Textline1: fmt.Sprint(displayValue)})
+	err := conn.Send(displayCommand)
 	if err != nil {
 		fmt.Println("Error sending display command:", err)
 	}
 
 	// Send a command to set the color of the knob on the panel
-	colorCommand := fmt.Sprintf(`{"HWCIDs":[%s],"HWCMode":{"State":4},"HWCColor":{"ColorRGB":{"Red":%d,"Green":%d,"Blue":%d}}}`, encoder, RedGain, GreenGain, BlueGain) + "\n"
-	_, err = conn.Write([]byte(colorCommand))
+	colorCommand := rawpanel.NewState(encoder).
+		Mode(rawpanel.StateOn).
+		ColorRGB(RedGain, GreenGain, BlueGain)
+	err = conn.Send(colorCommand)
 	if err != nil {
 		fmt.Println("Error sending color command:", err)
 	}
 }
 
-func processFader(conn net.Conn, value string) {
-
-	// Convert the value to an integer
-	position := 0
-	_, err := fmt.Sscanf(value, "%d", &position)
-	if err != nil {
-		fmt.Println("Error parsing fader value:", err)
-		return
-	}
+func processFader(conn *rawpanel.Client, position int) {
 	luminanceControl = position
 
 	// Update RedGain, GreenGain, and BlueGain based on the fader position
@@ -122,15 +116,18 @@ func processFader(conn net.Conn, value string) {
 	fmt.Println("RedGain:", RedGain, "GreenGain:", GreenGain, "BlueGain:", BlueGain)
 
 	// Send a command to set the color of the knob on the panel
-	colorCommand := fmt.Sprintf(`{"HWCIDs":[4,5,6],"HWCMode":{"State":4},"HWCColor":{"ColorRGB":{"Red":%d,"Green":%d,"Blue":%d}}}`, RedGain, GreenGain, BlueGain) + "\n"
-	_, err = conn.Write([]byte(colorCommand))
+	colorCommand := rawpanel.NewState(cfg.HWC.RedEncoder, cfg.HWC.GreenEncoder, cfg.HWC.BlueEncoder).
+		Mode(rawpanel.StateOn).
+		ColorRGB(RedGain, GreenGain, BlueGain)
+	err := conn.Send(colorCommand)
 	if err != nil {
 		fmt.Println("Error sending color command:", err)
 	}
 
 	// Send a command to set the value in the display
-	displayCommand := fmt.Sprintf(`{"HWCIDs":[24],"HWCText":{"Formatting":7,"Title":"Luminance","Textline1":"%d"}}`, luminanceControl) + "\n"
-	_, err = conn.Write([]byte(displayCommand))
+	displayCommand := rawpanel.NewState(cfg.HWC.FaderDisplay).
+		Text(rawpanel.HWCText{Formatting: 7, Title: "Luminance", Textline1: fmt.Sprint(luminanceControl)})
+	err = conn.Send(displayCommand)
 	if err != nil {
 		fmt.Println("Error sending display command:", err)
 	}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"NETIO/cron"
+	"NETIO/httpaction"
+	"NETIO/netio"
+	"rawpanel"
+	"rawpanel/config"
+)
+
+// Config holds the panel and NETIO device settings.
+//
+// The device settings at the top level describe a single NETIO unit. To
+// control several, list them in Devices instead; the top-level device
+// settings are then ignored.
+type Config struct {
+	Panel string `json:"panel"` // Raw Panel server (Frame Shot Pro), host:port
+
+	Device
+
+	// Devices are the NETIO units controlled from the panel. Buttons and
+	// meters refer to them by name.
+	Devices []Device `json:"devices"`
+
+	// Buttons bind panel buttons to NETIO outputs.
+	Buttons []Button `json:"buttons"`
+
+	// Meters show the power drawn by outputs on panel displays.
+	Meters []Meter `json:"meters"`
+
+	// Actions bind panel buttons to HTTP requests to other devices.
+	Actions []ActionButton `json:"actions"`
+
+	// Timers switch outputs a while after their button was held.
+	Timers []Timer `json:"timers"`
+
+	// Schedules switch outputs at given times.
+	Schedules []Schedule `json:"schedules"`
+
+	// Simulate starts a built-in fake NETIO device for every device and
+	// connects to it instead of the device URL, to try the panel without
+	// power sockets.
+	Simulate bool `json:"simulate"`
+
+	// PollInterval is how often the output states and meters are
+	// refreshed, in ms.
+	PollInterval int `json:"pollInterval"`
+}
+
+// Device is a NETIO unit.
+type Device struct {
+	Name     string `json:"name"`     // Referred to by buttons and meters
+	URL      string `json:"url"`      // NETIO JSON API, e.g. http://192.168.10.252/netio.json
+	Username string `json:"username"` // NETIO JSON API credentials
+	Password string `json:"password"`
+
+	// Timeout per request in ms and Retries of failed requests; see
+	// netio.Client.
+	Timeout int `json:"timeout"`
+	Retries int `json:"retries"`
+}
+
+// ActionButton sends an HTTP request when a button is pressed. The button
+// lights green when the request succeeded and flashes red when it failed.
+// The body template gets the ActionButton, e.g. {{.HWC}}.
+type ActionButton struct {
+	HWC  int `json:"hwc"`
+	Edge int `json:"edge"` // Edge of the button, 0 for any
+
+	httpaction.Action
+}
+
+// host returns the host of the URL, which requests are queued by.
+func (a *ActionButton) host() string {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return a.URL
+	}
+	return u.Host
+}
+
+// Meter shows the load and current of an output on a display. The LED of
+// the component warns when the load gets high.
+type Meter struct {
+	HWC    int    `json:"hwc"`
+	Device string `json:"device"` // Device name, empty for the first one
+	Output int    `json:"output"` // NETIO output, 0 for the whole device
+
+	// WarnLoad and MaxLoad, in W, turn the LED amber and red. 0 disables
+	// the warning.
+	WarnLoad float64 `json:"warnLoad"`
+	MaxLoad  float64 `json:"maxLoad"`
+
+	device int // Index into devices()
+}
+
+// Switch is an action on a NETIO output.
+type Switch struct {
+	Device string `json:"device"` // Device name, empty for the first one
+	Output int    `json:"output"` // NETIO output, counting from 1
+	Action string `json:"action"` // off, on, shortOff, shortOn or toggle
+
+	device int // Index into devices()
+	action netio.Action
+}
+
+// Button binds a panel button to an action on a NETIO output. Its LED is
+// green while the output is on and red while it is off.
+type Button struct {
+	HWC  int `json:"hwc"`
+	Edge int `json:"edge"` // Edge of the button, 0 for any
+
+	Switch
+}
+
+// Timer applies its action Delay seconds after the button was held for Hold
+// ms, e.g. to switch a lamp off when leaving the room. The display counts
+// down; pressing the button again cancels the timer.
+type Timer struct {
+	Button
+
+	Delay int `json:"delay"` // Seconds
+	Hold  int `json:"hold"`  // ms, 0 for 1000
+}
+
+// Schedule applies its action whenever the cron expression matches the
+// local time, e.g. "0 7 * * 1-5" for 7:00 on weekdays. See package cron.
+type Schedule struct {
+	Name string `json:"name"` // For messages
+	Cron string `json:"cron"`
+
+	Switch
+
+	schedule *cron.Schedule
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Panel: "192.168.11.166:9923",
+		Device: Device{
+			URL:      "http://192.168.10.252/netio.json",
+			Username: "netio",
+			Password: "netio",
+		},
+		PollInterval: 2000,
+	}
+}
+
+// defaultButtons are used when the config binds no buttons at all. They
+// are not part of defaultConfig, since the config file is decoded into the
+// existing slice elements and would inherit their fields.
+func defaultButtons() []Button {
+	return []Button{
+		{HWC: 1, Edge: 4, Switch: Switch{Output: 1, Action: "toggle"}},
+	}
+}
+
+// loadConfig reads the config file given by -config or NETIO_CONFIG, then
+// applies environment variables and flags on top.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("NETIO", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("NETIO_CONFIG"), "JSON config file")
+	panel := fs.String("panel", "", "Raw Panel server address, host:port (env NETIO_PANEL)")
+	url := fs.String("url", "", "NETIO JSON API URL, without devices list (env NETIO_URL)")
+	username := fs.String("username", "", "NETIO username, without devices list (env NETIO_USERNAME)")
+	simulate := fs.Bool("simulate", false, "use built-in simulated NETIO devices")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := config.Load(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Buttons) == 0 && len(cfg.Timers) == 0 && len(cfg.Actions) == 0 {
+		cfg.Buttons = defaultButtons()
+	}
+	config.Override(&cfg.Panel, os.Getenv("NETIO_PANEL"), *panel)
+	config.Override(&cfg.URL, os.Getenv("NETIO_URL"), *url)
+	config.Override(&cfg.Username, os.Getenv("NETIO_USERNAME"), *username)
+	// The password is deliberately not a flag so it does not show up in ps
+	config.Override(&cfg.Password, os.Getenv("NETIO_PASSWORD"))
+	if *simulate {
+		cfg.Simulate = true
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+	errs = append(errs, config.CheckAddr("panel", cfg.Panel))
+	devices := make(map[string]int)
+	for i, d := range cfg.devices() {
+		prefix := ""
+		if len(cfg.Devices) > 0 {
+			prefix = fmt.Sprintf("devices[%d].", i)
+			if d.Name == "" {
+				errs = append(errs, fmt.Errorf("%sname: missing", prefix))
+			}
+		}
+		if _, ok := devices[d.Name]; ok {
+			errs = append(errs, fmt.Errorf("%sname: duplicate device %q", prefix, d.Name))
+		}
+		devices[d.Name] = i
+		if !cfg.Simulate {
+			errs = append(errs, config.CheckURL(prefix+"url", d.URL))
+		}
+		if d.Timeout < 0 || d.Retries < 0 {
+			errs = append(errs, fmt.Errorf("%stimeout, %sretries: must not be negative", prefix, prefix))
+		}
+	}
+	// An empty name picks the first device
+	device := func(name string) (int, bool) {
+		if name == "" {
+			return 0, true
+		}
+		i, ok := devices[name]
+		return i, ok
+	}
+	if cfg.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("pollInterval: invalid interval %d", cfg.PollInterval))
+	}
+
+	// Four-way buttons may bind each edge, so only HWC and edge together
+	// must be unique. Edge 0 matches every edge, so it must be the only
+	// binding of its HWC.
+	var ok bool
+	used := make(map[[2]int]string)
+	edges := make(map[int]string) // First binding of a specific edge by HWC
+	button := func(name string, hwc, edge int) {
+		if hwc < 1 {
+			errs = append(errs, fmt.Errorf("%s.hwc: invalid HWC ID %d", name, hwc))
+		}
+		if edge < 0 {
+			errs = append(errs, fmt.Errorf("%s.edge: invalid edge %d", name, edge))
+		}
+		if other, ok := used[[2]int{hwc, edge}]; ok {
+			errs = append(errs, fmt.Errorf("%s: HWC %d edge %d is already used by %s", name, hwc, edge, other))
+		} else if other, ok := used[[2]int{hwc, 0}]; ok && edge != 0 {
+			errs = append(errs, fmt.Errorf("%s: HWC %d is already used on any edge by %s", name, hwc, other))
+		} else if other, ok := edges[hwc]; ok && edge == 0 {
+			errs = append(errs, fmt.Errorf("%s: HWC %d edge 0 overlaps %s", name, hwc, other))
+		}
+		used[[2]int{hwc, edge}] = name
+		if _, ok := edges[hwc]; !ok && edge != 0 {
+			edges[hwc] = name
+		}
+	}
+	for i := range cfg.Buttons {
+		b := &cfg.Buttons[i]
+		name := fmt.Sprintf("buttons[%d]", i)
+		button(name, b.HWC, b.Edge)
+		errs = append(errs, b.Switch.validate(name, device))
+	}
+	for i := range cfg.Timers {
+		t := &cfg.Timers[i]
+		name := fmt.Sprintf("timers[%d]", i)
+		button(name, t.HWC, t.Edge)
+		if t.Action == "" {
+			t.Action = "off"
+		}
+		errs = append(errs, t.Switch.validate(name, device))
+		if t.Delay < 1 {
+			errs = append(errs, fmt.Errorf("%s.delay: invalid delay %d", name, t.Delay))
+		}
+		if t.Hold < 0 {
+			errs = append(errs, fmt.Errorf("%s.hold: invalid time %d", name, t.Hold))
+		}
+	}
+	for i := range cfg.Schedules {
+		s := &cfg.Schedules[i]
+		name := fmt.Sprintf("schedules[%d]", i)
+		errs = append(errs, s.Switch.validate(name, device))
+		schedule, err := cron.Parse(s.Cron)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.cron: %w", name, err))
+		} else if schedule.Next(time.Now()).IsZero() {
+			errs = append(errs, fmt.Errorf("%s.cron: %q never matches", name, s.Cron))
+		}
+		s.schedule = schedule
+	}
+	for i := range cfg.Meters {
+		m := &cfg.Meters[i]
+		if m.device, ok = device(m.Device); !ok {
+			errs = append(errs, fmt.Errorf("meters[%d].device: unknown device %q", i, m.Device))
+		}
+		if m.Output < 0 {
+			errs = append(errs, fmt.Errorf("meters[%d].output: invalid output %d", i, m.Output))
+		}
+		if m.WarnLoad < 0 || m.MaxLoad < 0 || (m.WarnLoad > 0 && m.MaxLoad > 0 && m.WarnLoad > m.MaxLoad) {
+			errs = append(errs, fmt.Errorf("meters[%d]: invalid loads %g and %g", i, m.WarnLoad, m.MaxLoad))
+		}
+	}
+	for i := range cfg.Actions {
+		a := &cfg.Actions[i]
+		name := fmt.Sprintf("actions[%d]", i)
+		button(name, a.HWC, a.Edge)
+		errs = append(errs, a.Validate(name))
+	}
+	errs = append(errs, config.CheckHWCs(cfg.meterHWCs()))
+	return errors.Join(errs...)
+}
+
+// validate checks the action and resolves the device name with device.
+func (s *Switch) validate(name string, device func(string) (int, bool)) error {
+	var errs []error
+	if s.Output < 1 {
+		errs = append(errs, fmt.Errorf("%s.output: invalid output %d", name, s.Output))
+	}
+	action, err := netio.ParseAction(s.Action)
+	if err != nil || action == netio.ActionNoChange {
+		errs = append(errs, fmt.Errorf("%s.action: invalid action %q", name, s.Action))
+	}
+	s.action = action
+	var ok bool
+	if s.device, ok = device(s.Device); !ok {
+		errs = append(errs, fmt.Errorf("%s.device: unknown device %q", name, s.Device))
+	}
+	return errors.Join(errs...)
+}
+
+// devices returns the NETIO units to control: the Devices list, or else the
+// top-level device settings.
+func (cfg *Config) devices() []Device {
+	if len(cfg.Devices) == 0 {
+		return []Device{cfg.Device}
+	}
+	return cfg.Devices
+}
+
+// deviceName returns the name of device i for messages.
+func (cfg *Config) deviceName(i int) string {
+	if name := cfg.devices()[i].Name; name != "" {
+		return name
+	}
+	return "NETIO"
+}
+
+// meterHWCs returns the HWC IDs of the meters by setting name.
+func (cfg *Config) meterHWCs() map[string]int {
+	hwcs := make(map[string]int)
+	for i, m := range cfg.Meters {
+		hwcs[fmt.Sprintf("meters[%d].hwc", i)] = m.HWC
+	}
+	return hwcs
+}
+
+// hwcs returns the configured HWC IDs by setting name.
+func (cfg *Config) hwcs() map[string]int {
+	hwcs := make(map[string]int)
+	for i, b := range cfg.Buttons {
+		hwcs[fmt.Sprintf("buttons[%d].hwc", i)] = b.HWC
+	}
+	for i, a := range cfg.Actions {
+		hwcs[fmt.Sprintf("actions[%d].hwc", i)] = a.HWC
+	}
+	for i, t := range cfg.Timers {
+		hwcs[fmt.Sprintf("timers[%d].hwc", i)] = t.HWC
+	}
+	for name, hwc := range cfg.meterHWCs() {
+		hwcs[name] = hwc
+	}
+	return hwcs
+}
+
+// matches reports whether an event presses the button.
+func (b *Button) matches(ev rawpanel.Event) bool {
+	return pressed(ev, b.HWC, b.Edge)
+}
+
+// hold returns how long the button must be held to start the timer.
+func (t *Timer) hold() time.Duration {
+	if t.Hold == 0 {
+		return time.Second
+	}
+	return time.Duration(t.Hold) * time.Millisecond
+}
+
+// matches reports whether an event presses the button.
+func (a *ActionButton) matches(ev rawpanel.Event) bool {
+	return pressed(ev, a.HWC, a.Edge)
+}
+
+// pressed reports whether ev is a press of hwc on edge, or on any edge if
+// edge is 0.
+func pressed(ev rawpanel.Event, hwc, edge int) bool {
+	return ev.HWC == hwc && (edge == 0 || int(ev.Edge) == edge) && ev.Trigger == rawpanel.TriggerDown
+}
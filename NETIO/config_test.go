@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, data string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestConfigButtonsDoNotInheritDefaults(t *testing.T) {
+	cfg := loadTestConfig(t, `{"buttons": [{"hwc": 7, "output": 3, "action": "on"}]}`)
+	if len(cfg.Buttons) != 1 {
+		t.Fatalf("got %d buttons, want 1", len(cfg.Buttons))
+	}
+	if b := cfg.Buttons[0]; b.HWC != 7 || b.Edge != 0 || b.Output != 3 || b.Action != "on" {
+		t.Errorf("got button %+v, want HWC 7, any edge, output 3 on", b)
+	}
+}
+
+func TestConfigDefaultButtons(t *testing.T) {
+	cfg := loadTestConfig(t, `{}`)
+	if len(cfg.Buttons) != 1 || cfg.Buttons[0].HWC != 1 || cfg.Buttons[0].Edge != 4 {
+		t.Errorf("got buttons %+v, want the default toggle on HWC 1 edge 4", cfg.Buttons)
+	}
+
+	cfg = loadTestConfig(t, `{"timers": [{"hwc": 2, "output": 1, "delay": 60}]}`)
+	if len(cfg.Buttons) != 0 {
+		t.Errorf("got buttons %+v with timers configured, want none", cfg.Buttons)
+	}
+}
+
+func TestConfigEdgeOverlap(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{`{"buttons": [{"hwc": 1, "edge": 1, "output": 1, "action": "on"}, {"hwc": 1, "edge": 4, "output": 2, "action": "on"}]}`, true},
+		{`{"buttons": [{"hwc": 1, "output": 1, "action": "on"}, {"hwc": 2, "edge": 4, "output": 2, "action": "on"}]}`, true},
+		{`{"buttons": [{"hwc": 1, "edge": 4, "output": 1, "action": "on"}, {"hwc": 1, "edge": 4, "output": 2, "action": "on"}]}`, false},
+		{`{"buttons": [{"hwc": 1, "output": 1, "action": "on"}, {"hwc": 1, "edge": 4, "output": 2, "action": "on"}]}`, false},
+		{`{"buttons": [{"hwc": 1, "edge": 4, "output": 1, "action": "on"}, {"hwc": 1, "output": 2, "action": "on"}]}`, false},
+		{`{"buttons": [{"hwc": 1, "edge": 4, "output": 1, "action": "on"}], "timers": [{"hwc": 1, "output": 2, "delay": 60}]}`, false},
+		{`{"buttons": [{"hwc": 1, "output": 1, "action": "on"}], "actions": [{"hwc": 1, "edge": 8, "url": "http://127.0.0.1/"}]}`, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig([]string{"-config", path})
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.data, err)
+		} else if !tt.ok && (err == nil || !strings.Contains(err.Error(), "HWC 1")) {
+			t.Errorf("%s: got error %v, want overlapping bindings of HWC 1", tt.data, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"*/-1 * * * *",
+		"5-1 * * * *",
+		"1- * * * *",
+		"-1 * * * *",
+		"1,,2 * * * *",
+		"a * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * 1-8",
+	}
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): got no error", expr)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		time string
+		want bool
+	}{
+		{"* * * * *", "2026-03-02 10:11", true},
+		{"30 7 * * 1-5", "2026-03-02 07:30", true}, // Monday
+		{"30 7 * * 1-5", "2026-03-02 07:31", false},
+		{"30 7 * * 1-5", "2026-03-07 07:30", false}, // Saturday
+		{"0 8-18/2 * * *", "2026-03-02 12:00", true},
+		{"0 8-18/2 * * *", "2026-03-02 13:00", false},
+		{"0 8-18/2 * * *", "2026-03-02 20:00", false},
+		{"*/15 * * * *", "2026-03-02 10:45", true},
+		{"*/15 * * * *", "2026-03-02 10:50", false},
+		{"5/15 * * * *", "2026-03-02 10:50", true},
+		{"5/15 * * * *", "2026-03-02 10:00", false},
+		{"0 9,12,18 * * *", "2026-03-02 12:00", true},
+		{"0 9,12,18 * * *", "2026-03-02 13:00", false},
+		{"0 0 1 1,7 *", "2026-07-01 00:00", true},
+		{"0 0 1 1,7 *", "2026-06-01 00:00", false},
+
+		// Sunday is 0 and 7
+		{"0 12 * * 0", "2026-03-01 12:00", true},
+		{"0 12 * * 7", "2026-03-01 12:00", true},
+		{"0 12 * * 6-7", "2026-03-01 12:00", true},
+		{"0 12 * * 7", "2026-03-02 12:00", false},
+
+		// With both day fields restricted, either of them will do
+		{"0 0 13 * 5", "2026-03-13 00:00", true}, // Friday the 13th
+		{"0 0 13 * 5", "2026-03-06 00:00", true}, // Friday
+		{"0 0 13 * 5", "2026-04-13 00:00", true}, // Monday the 13th
+		{"0 0 13 * 5", "2026-03-10 00:00", false},
+
+		// With one of them "*", only the other one counts
+		{"0 0 13 * *", "2026-03-06 00:00", false},
+		{"0 0 * * 5", "2026-04-13 00:00", false},
+		{"0 0 */2 * 5", "2026-03-13 00:00", true},  // Odd day and Friday
+		{"0 0 */2 * 5", "2026-03-06 00:00", false}, // Even day
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Matches(date(tt.time)); got != tt.want {
+			t.Errorf("%q at %s: got %v, want %v", tt.expr, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from string
+		want string // Empty if it never matches
+	}{
+		{"*/15 * * * *", "2026-03-02 10:07", "2026-03-02 10:15"},
+		{"*/15 * * * *", "2026-03-02 10:45", "2026-03-02 11:00"},
+		{"*/15 * * * *", "2026-03-02 23:59", "2026-03-03 00:00"},
+		{"30 7 * * 1-5", "2026-03-13 08:00", "2026-03-16 07:30"}, // Friday to Monday
+		{"0 0 1 1 *", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"0 0 13 * 5", "2026-03-13 00:00", "2026-03-20 00:00"},
+		{"0 0 13 * 5", "2026-04-10 00:00", "2026-04-13 00:00"},
+		{"0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"0 0 30 2 *", "2026-01-01 00:00", ""},
+		{"0 0 31 4,6,9,11 *", "2026-01-01 00:00", ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		got := s.Next(date(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s: got %s, want never", tt.expr, tt.from, got)
+			}
+			continue
+		}
+		if want := date(tt.want); !got.Equal(want) {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from, got, want)
+		}
+	}
+}
+
+func TestNextSeconds(t *testing.T) {
+	// Seconds into a matching minute still give the next one
+	s, err := Parse("* * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := date("2026-03-02 10:07").Add(30 * time.Second)
+	if got, want := s.Next(from), date("2026-03-02 10:08"); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
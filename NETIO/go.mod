@@ -1,3 +1,7 @@
 module NETIO
 
 go 1.21.0
+
+require rawpanel v0.0.0
+
+replace rawpanel => ../rawpanel
@@ -0,0 +1,219 @@
+// Package httpaction sends configurable HTTP requests to devices, with
+// timeouts and retries, so panel buttons can drive any HTTP-controlled gear.
+//
+// An Action is meant to be decoded from a tool's JSON config:
+//
+//	{
+//	  "method": "POST",
+//	  "url": "http://192.168.10.40/api/preset",
+//	  "headers": {"Content-Type": "application/json"},
+//	  "body": "{\"preset\": {{.HWC}}}",
+//	  "expectStatus": [200, 204],
+//	  "timeout": 2000,
+//	  "retries": 2
+//	}
+package httpaction
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Defaults for unset fields.
+const (
+	DefaultTimeout    = 5 * time.Second
+	DefaultRetryDelay = 500 * time.Millisecond
+)
+
+// maxBody limits how much of a response is kept.
+const maxBody = 1 << 20
+
+// Action is an HTTP request to send.
+type Action struct {
+	Method  string            `json:"method"` // Defaults to POST with a body, GET without
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+
+	// Username and Password, if set, are sent as basic authentication.
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Body is a text/template executed with the data passed to Do.
+	Body string `json:"body"`
+
+	// ExpectStatus lists the status codes that mean success. Empty accepts
+	// any 2xx status.
+	ExpectStatus []int `json:"expectStatus"`
+
+	// Timeout is the time allowed per attempt in ms, 0 for DefaultTimeout.
+	Timeout int `json:"timeout"`
+
+	// Retries is how often a failed attempt is repeated. Requests are only
+	// retried on network errors and 5xx statuses, after RetryDelay ms
+	// (0 for DefaultRetryDelay) doubling on every retry.
+	Retries    int `json:"retries"`
+	RetryDelay int `json:"retryDelay"`
+
+	tmpl *template.Template
+}
+
+// Result is the outcome of an action.
+type Result struct {
+	Status   int    // Status code of the last attempt
+	Body     []byte // Response body of the last attempt
+	Attempts int
+}
+
+// StatusError is returned when the device answered with a status not in
+// ExpectStatus.
+type StatusError struct {
+	Status int
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("httpaction: unexpected status %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// Validate checks the settings and prepares the body template. Call it
+// once after loading the config; name prefixes the errors.
+func (a *Action) Validate(name string) error {
+	var errs []error
+	if u, err := url.Parse(a.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.url: invalid URL %q", name, a.URL))
+	}
+	if a.Method != "" && strings.ToUpper(a.Method) != a.Method {
+		errs = append(errs, fmt.Errorf("%s.method: must be upper case, e.g. POST", name))
+	}
+	if a.Timeout < 0 || a.Retries < 0 || a.RetryDelay < 0 {
+		errs = append(errs, fmt.Errorf("%s: timeout, retries and retryDelay must not be negative", name))
+	}
+	for _, status := range a.ExpectStatus {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("%s.expectStatus: invalid status %d", name, status))
+		}
+	}
+
+	tmpl, err := a.template()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s.body: %w", name, err))
+	}
+	a.tmpl = tmpl
+	return errors.Join(errs...)
+}
+
+// Do renders the body with data and sends the request.
+func (a *Action) Do(data interface{}) (*Result, error) {
+	var body []byte
+	if a.Body != "" {
+		tmpl, err := a.template()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("httpaction: body: %w", err)
+		}
+		body = buf.Bytes()
+	}
+	return a.Send(body)
+}
+
+// Send sends the request with a ready-made body, nil for none, retrying as
+// configured.
+func (a *Action) Send(body []byte) (*Result, error) {
+	delay := DefaultRetryDelay
+	if a.RetryDelay > 0 {
+		delay = time.Duration(a.RetryDelay) * time.Millisecond
+	}
+
+	result := &Result{}
+	for {
+		result.Attempts++
+		err := a.attempt(body, result)
+		if err == nil || result.Attempts > a.Retries || !retryable(err) {
+			return result, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// attempt sends the request once and fills in result.
+func (a *Action) attempt(body []byte, result *Result) error {
+	method := a.Method
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+
+	req, err := http.NewRequest(method, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, value := range a.Headers {
+		req.Header.Set(key, value)
+	}
+	if a.Username != "" || a.Password != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+
+	timeout := DefaultTimeout
+	if a.Timeout > 0 {
+		timeout = time.Duration(a.Timeout) * time.Millisecond
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	result.Body, err = io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return err
+	}
+	if !a.expected(resp.StatusCode) {
+		return &StatusError{Status: resp.StatusCode, Body: result.Body}
+	}
+	return nil
+}
+
+func (a *Action) expected(status int) bool {
+	if len(a.ExpectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range a.ExpectStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Action) template() (*template.Template, error) {
+	if a.tmpl != nil || a.Body == "" {
+		return a.tmpl, nil
+	}
+	return template.New("body").Option("missingkey=error").Parse(a.Body)
+}
+
+// retryable reports whether a failed attempt may succeed when repeated:
+// network errors and server errors, but not rejected requests.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 500
+	}
+	return true
+}
@@ -1,94 +1,356 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net"
-	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"NETIO/netio"
+	"NETIO/netio/netiosim"
+	"rawpanel"
 )
 
-const (
-	frameShotProAddr = "192.168.11.166:9923"
-	lampAPIAddr      = "http://192.168.10.252/netio.json"
-	lampUsername     = "netio"
-	lampPassword     = "netio"
+var cfg *Config
+var conn *rawpanel.Client
+var devices []*netio.Client // Indexed like cfg.devices()
+
+// flashInterval is the blink rate of a button whose request failed.
+const flashInterval = 150 * time.Millisecond
+
+// queueSize is the number of button presses waiting for a device before
+// more presses are refused.
+const queueSize = 16
+
+// switches holds the button presses waiting per device. Each device has its
+// own goroutine sending them in order, so a slow device neither holds up
+// the panel nor has its presses reordered.
+var switches []chan *Button
+
+// actions holds the action button presses waiting per host, sent in order
+// like switches.
+var actions = make(map[string]chan *ActionButton)
+
+var (
+	statusMu sync.Mutex
+	statuses []*netio.Status // Last known status per device, nil if unknown
+	flashing = make(map[int]bool)
 )
 
 func main() {
-	// Connect to Frame Shot Pro
-	conn, err := net.Dial("tcp", frameShotProAddr)
+	// Load the configuration from file, environment and flags
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error connecting to Frame Shot Pro:", err)
-		return
+		fmt.Println("Error loading configuration:", err)
+		os.Exit(2)
 	}
-	defer conn.Close()
 
-	// Send "list\n" to initialize the connection
-	_, err = conn.Write([]byte("list\n"))
-	if err != nil {
-		fmt.Println("Error sending 'list' to Frame Shot Pro:", err)
-		return
+	for i, d := range cfg.devices() {
+		if cfg.Simulate {
+			sim, err := netiosim.New("127.0.0.1:0", netiosim.Config{
+				DeviceName: cfg.deviceName(i),
+				Username:   d.Username,
+				Password:   d.Password,
+				On:         []int{1},
+				Loads:      []float64{60, 150, 800, 2000},
+			})
+			if err != nil {
+				fmt.Println("Failed to start simulated NETIO:", err)
+				os.Exit(1)
+			}
+			defer sim.Close()
+			d.URL = sim.URL()
+			fmt.Printf("Simulated %s listening on %s\n", cfg.deviceName(i), d.URL)
+		}
+		device := netio.NewClient(d.URL, d.Username, d.Password)
+		device.Timeout = d.Timeout
+		device.Retries = d.Retries
+		devices = append(devices, device)
+	}
+	statuses = make([]*netio.Status, len(devices))
+	for range devices {
+		queue := make(chan *Button, queueSize)
+		switches = append(switches, queue)
+		go runSwitches(queue)
+	}
+	for i := range cfg.Actions {
+		host := cfg.Actions[i].host()
+		if _, ok := actions[host]; !ok {
+			queue := make(chan *ActionButton, queueSize)
+			actions[host] = queue
+			go runActions(queue)
+		}
 	}
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		message := scanner.Text()
+	// Connect to Frame Shot Pro, reconnecting whenever it goes away
+	conn = rawpanel.Connect(cfg.Panel, rawpanel.Options{
+		OnStateChange: func(state rawpanel.ConnState) {
+			fmt.Println("Frame Shot Pro", state)
+		},
+		OnTopology: func(topology *rawpanel.Topology) {
+			if err := topology.CheckHWCs(cfg.hwcs()); err != nil {
+				fmt.Println("Warning:", err)
+			}
+		},
+		OnError: func(err error) {
+			fmt.Println("Skipping malformed event:", err)
+		},
+	})
+	defer conn.Close()
 
-		// Check if the message is one of the expected patterns
-		if message == "HWC#1.4=Down" {
-			// Toggle the lamp
-			toggleLamp()
+	// Show the output states on the buttons and keep them up to date
+	for i := range devices {
+		go pollDevice(i)
+	}
+	for i := range cfg.Schedules {
+		go runSchedule(&cfg.Schedules[i])
+	}
+
+	for ev := range conn.Events() {
+		for i := range cfg.Buttons {
+			if b := &cfg.Buttons[i]; b.matches(ev) {
+				queueSwitch(b)
+			}
+		}
+		for i := range cfg.Timers {
+			handleTimer(&cfg.Timers[i], ev)
+		}
+		for i := range cfg.Actions {
+			if a := &cfg.Actions[i]; a.matches(ev) {
+				queueAction(a)
+			}
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading from Frame Shot Pro:", err)
+// queueAction queues the HTTP request of a button without waiting. The
+// press is refused if its host is too far behind.
+func queueAction(a *ActionButton) {
+	select {
+	case actions[a.host()] <- a:
+	default:
+		fmt.Printf("Error: %s is busy, ignoring button %d\n", a.host(), a.HWC)
+		flashAction(a)
 	}
 }
 
-func toggleLamp() {
-	// Create JSON payload
-	payload := map[string]interface{}{
-		"Outputs": []map[string]interface{}{
-			{
-				"ID":     1,
-				"Action": 4,
-			},
-		},
+// runActions sends the queued requests of a host one at a time.
+func runActions(queue <-chan *ActionButton) {
+	for a := range queue {
+		runAction(a)
 	}
+}
 
-	jsonData, err := json.Marshal(payload)
+// runAction sends the HTTP request of a button.
+func runAction(a *ActionButton) {
+	result, err := a.Do(a)
 	if err != nil {
-		fmt.Println("Error encoding JSON payload:", err)
+		fmt.Printf("Error sending request to %s: %v\n", a.URL, err)
+		flashAction(a)
 		return
 	}
+	fmt.Printf("Request to %s: status %d after %d attempts\n", a.URL, result.Status, result.Attempts)
+	send(rawpanel.NewState(a.HWC).Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorGreen))
+}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", lampAPIAddr, bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Println("Error creating HTTP request:", err)
-		return
+// flashAction reports a failed request, then turns the button off.
+func flashAction(a *ActionButton) {
+	flashError(a.HWC, func() {
+		send(rawpanel.NewState(a.HWC).Mode(rawpanel.StateOff))
+	})
+}
+
+// queueSwitch queues a button press for its NETIO device without waiting.
+// The press is refused if the device is too far behind.
+func queueSwitch(b *Button) {
+	select {
+	case switches[b.device] <- b:
+	default:
+		fmt.Printf("Error: %s is busy, ignoring button %d\n", cfg.deviceName(b.device), b.HWC)
+		flashButton(b)
 	}
+}
+
+// runSwitches sends the queued button presses of a device one at a time.
+func runSwitches(queue <-chan *Button) {
+	for b := range queue {
+		switchOutput(b)
+	}
+}
 
-	req.SetBasicAuth(lampUsername, lampPassword)
-	req.Header.Set("Content-Type", "application/json")
+// switchOutput sends the action of a button to its NETIO device.
+func switchOutput(b *Button) {
+	if err := setOutput(&b.Switch); err != nil {
+		fmt.Println("Error", err)
+		flashButton(b)
+	}
+}
+
+// flashButton reports a failed press, then shows the outputs again.
+func flashButton(b *Button) {
+	flashError(b.HWC, func() {
+		statusMu.Lock()
+		status := statuses[b.device]
+		statusMu.Unlock()
+		showOutputs(b.device, status)
+	})
+}
 
-	// Send HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// setOutput sends an action to a NETIO device and shows the new state.
+func setOutput(s *Switch) error {
+	name := cfg.deviceName(s.device)
+	status, err := devices[s.device].Set(s.Output, s.action)
 	if err != nil {
-		fmt.Println("Error sending HTTP request to toggle lamp:", err)
-		return
+		return fmt.Errorf("switching %s output %d %s: %w", name, s.Output, s.action, err)
 	}
-	defer resp.Body.Close()
+	fmt.Printf("%s output %d switched %s\n", name, s.Output, s.action)
+	update(s.device, status)
+	return nil
+}
 
-	// Check the response
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Error toggling lamp. Status code:", resp.StatusCode)
-		return
+// pollDevice reads the state of a device every PollInterval and shows it
+// on the buttons and meters.
+func pollDevice(device int) {
+	ticker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	name := cfg.deviceName(device)
+	failing := false
+	for ; ; <-ticker.C {
+		status, err := devices[device].Status()
+		// Only report when the device goes away or comes back
+		if err != nil && !failing {
+			fmt.Printf("Error reading %s status: %v\n", name, err)
+		} else if err == nil && failing {
+			fmt.Printf("%s status available again\n", name)
+		}
+		failing = err != nil
+		update(device, status)
+	}
+}
+
+// update remembers the status of a device and shows it on the panel.
+func update(device int, status *netio.Status) {
+	statusMu.Lock()
+	statuses[device] = status
+	statusMu.Unlock()
+	showOutputs(device, status)
+	showMeters(device, status)
+}
+
+// flashError blinks a button red a few times to report a failed request,
+// then calls restore to show its normal state again.
+func flashError(hwc int, restore func()) {
+	statusMu.Lock()
+	flashing[hwc] = true
+	statusMu.Unlock()
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			send(rawpanel.NewState(hwc).Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorRed))
+			time.Sleep(flashInterval)
+			send(rawpanel.NewState(hwc).Mode(rawpanel.StateOff))
+			time.Sleep(flashInterval)
+		}
+		statusMu.Lock()
+		delete(flashing, hwc)
+		statusMu.Unlock()
+		restore()
+	}()
+}
+
+// isFlashing reports whether hwc is busy reporting an error.
+func isFlashing(hwc int) bool {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return flashing[hwc]
+}
+
+// send sends a state to the panel. While disconnected the state is kept and
+// sent on reconnect.
+func send(state *rawpanel.State) {
+	if err := conn.Send(state); err != nil && err != rawpanel.ErrNotConnected {
+		fmt.Println("Error sending to Frame Shot Pro:", err)
 	}
+}
 
-	fmt.Println("Lamp toggled successfully!")
+// showOutputs lights the buttons and idle timers of a device green if their
+// output is on and red if it is off. Buttons are dark while the state is
+// unknown, e.g. status is nil.
+func showOutputs(device int, status *netio.Status) {
+	for i := range cfg.Buttons {
+		if b := &cfg.Buttons[i]; b.device == device && !isFlashing(b.HWC) {
+			send(outputState(b, status))
+		}
+	}
+	for i := range cfg.Timers {
+		if t := &cfg.Timers[i]; t.device == device && !isFlashing(t.HWC) && !isCounting(t) {
+			send(outputState(&t.Button, status).Text(timerText(t, time.Duration(t.Delay)*time.Second)))
+		}
+	}
+}
+
+// outputState returns the LED state of a button for the state of its
+// output.
+func outputState(b *Button, status *netio.Status) *rawpanel.State {
+	state := rawpanel.NewState(b.HWC).Mode(rawpanel.StateOff)
+	if status != nil {
+		if output, ok := status.Output(b.Output); ok && output.On() {
+			state.Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorGreen)
+		} else if ok {
+			state.Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorRed)
+		}
+	}
+	return state
+}
+
+// showMeters shows the load and current of the metered outputs of a
+// device, or dashes while the status is unknown.
+func showMeters(device int, status *netio.Status) {
+	for _, m := range cfg.Meters {
+		if m.device != device {
+			continue
+		}
+		text := rawpanel.HWCText{Formatting: 7, Title: "Total", Textline1: "- W", Textline2: "- A"}
+		if m.Output > 0 {
+			text.Title = fmt.Sprintf("Output %d", m.Output)
+		}
+
+		color := rawpanel.ColorGreen
+		load, current, ok := measure(status, m.Output)
+		if ok {
+			text.Textline1 = fmt.Sprintf("%.0f W", load)
+			text.Textline2 = fmt.Sprintf("%.2f A", current/1000)
+			switch {
+			case m.MaxLoad > 0 && load >= m.MaxLoad:
+				color = rawpanel.ColorRed
+				text.Inverted = true
+			case m.WarnLoad > 0 && load >= m.WarnLoad:
+				color = rawpanel.ColorAmber
+			}
+		}
+
+		state := rawpanel.NewState(m.HWC).Text(text).Mode(rawpanel.StateOn).ColorIndex(color)
+		if !ok {
+			state.Mode(rawpanel.StateOff)
+		}
+		send(state)
+	}
+}
+
+// measure returns the load in W and current in mA of an output, or of the
+// whole device for output 0.
+func measure(status *netio.Status, output int) (load, current float64, ok bool) {
+	if status == nil {
+		return 0, 0, false
+	}
+	if output == 0 {
+		return status.GlobalMeasure.TotalLoad, status.GlobalMeasure.TotalCurrent, true
+	}
+	o, ok := status.Output(output)
+	if !ok {
+		return 0, 0, false
+	}
+	return o.Load, o.Current, true
 }
@@ -0,0 +1,184 @@
+// Package netio implements a client for the JSON API of NETIO power
+// sockets (netio.json).
+//
+// A GET returns the device status; a POST with a list of output actions
+// switches outputs and answers with the status after the change. Both use
+// HTTP basic authentication.
+package netio
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"NETIO/httpaction"
+)
+
+// Action is what a POST does to an output.
+type Action int
+
+const (
+	ActionOff      Action = 0 // Switch off
+	ActionOn       Action = 1 // Switch on
+	ActionShortOff Action = 2 // Switch off for the output's delay, then on again
+	ActionShortOn  Action = 3 // Switch on for the output's delay, then off again
+	ActionToggle   Action = 4 // Invert the state
+	ActionNoChange Action = 5 // Leave the state alone
+)
+
+var actionNames = map[Action]string{
+	ActionOff:      "off",
+	ActionOn:       "on",
+	ActionShortOff: "shortOff",
+	ActionShortOn:  "shortOn",
+	ActionToggle:   "toggle",
+	ActionNoChange: "noChange",
+}
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ParseAction returns the action named name, e.g. "shortOn".
+func ParseAction(name string) (Action, error) {
+	for a, n := range actionNames {
+		if n == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("netio: unknown action %q", name)
+}
+
+// Status is the content of netio.json. Metering fields are zero on models
+// without power measurement.
+type Status struct {
+	Agent         Agent
+	GlobalMeasure GlobalMeasure
+	Outputs       []Output
+}
+
+// Agent describes the device.
+type Agent struct {
+	Model        string
+	Version      string
+	JSONVer      string
+	DeviceName   string
+	VendorID     int
+	OemID        int
+	SerialNumber string
+	Uptime       int // Seconds
+	Time         string
+	NumOutputs   int
+}
+
+// GlobalMeasure holds the measurements of the whole device.
+type GlobalMeasure struct {
+	Voltage            float64 // V
+	Frequency          float64 // Hz
+	TotalCurrent       float64 // mA
+	OverallPowerFactor float64
+	TotalLoad          float64 // W
+	TotalEnergy        float64 // Wh since EnergyStart
+	EnergyStart        string
+}
+
+// Output is the state of one power output.
+type Output struct {
+	ID     int
+	Name   string
+	State  int    // 1 if on, 0 if off
+	Action Action // Last action, 6 in status responses
+	Delay  int    // Duration of short on and short off in ms
+
+	Current       float64 // mA
+	PowerFactor   float64
+	Phase         float64 // Degrees
+	Load          float64 // W
+	Energy        float64 // Wh since GlobalMeasure.EnergyStart
+	ReverseEnergy float64 // Wh fed back
+}
+
+// On reports whether the output is switched on.
+func (o *Output) On() bool { return o.State == 1 }
+
+// Output returns the output with the given ID.
+func (s *Status) Output(id int) (*Output, bool) {
+	for i := range s.Outputs {
+		if s.Outputs[i].ID == id {
+			return &s.Outputs[i], true
+		}
+	}
+	return nil, false
+}
+
+// Client talks to one NETIO device.
+type Client struct {
+	URL      string // e.g. http://192.168.10.252/netio.json
+	Username string
+	Password string
+
+	// Timeout is the time allowed per request in ms, 0 for the default of
+	// package httpaction. Retries is how often a failed request is repeated;
+	// toggles and short actions are never repeated, since a request that
+	// timed out may still have switched the output.
+	Timeout int
+	Retries int
+}
+
+// NewClient returns a client for the JSON API at url.
+func NewClient(url, username, password string) *Client {
+	return &Client{URL: url, Username: username, Password: password}
+}
+
+// Status fetches the device status.
+func (c *Client) Status() (*Status, error) {
+	return c.do(http.MethodGet, nil, c.Retries)
+}
+
+// Set applies an action to an output and returns the status after it.
+func (c *Client) Set(output int, action Action) (*Status, error) {
+	type outputAction struct {
+		ID     int
+		Action Action
+	}
+	body, err := json.Marshal(struct{ Outputs []outputAction }{
+		Outputs: []outputAction{{ID: output, Action: action}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	retries := 0
+	if action == ActionOn || action == ActionOff {
+		retries = c.Retries
+	}
+	return c.do(http.MethodPost, body, retries)
+}
+
+func (c *Client) do(method string, body []byte, retries int) (*Status, error) {
+	a := &httpaction.Action{
+		Method:   method,
+		URL:      c.URL,
+		Username: c.Username,
+		Password: c.Password,
+		Timeout:  c.Timeout,
+		Retries:  retries,
+	}
+	if body != nil {
+		a.Headers = map[string]string{"Content-Type": "application/json"}
+	}
+
+	result, err := a.Send(body)
+	if err != nil {
+		return nil, fmt.Errorf("netio: %s %s: %w", method, c.URL, err)
+	}
+
+	var status Status
+	if err := json.Unmarshal(result.Body, &status); err != nil {
+		return nil, fmt.Errorf("netio: invalid response: %w", err)
+	}
+	return &status, nil
+}
@@ -0,0 +1,146 @@
+package netio_test
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"NETIO/httpaction"
+	"NETIO/netio"
+	"NETIO/netio/netiosim"
+)
+
+func newSim(t *testing.T, cfg netiosim.Config) *netiosim.Server {
+	t.Helper()
+	cfg.Username, cfg.Password = "netio", "secret"
+	sim, err := netiosim.New("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	return sim
+}
+
+// outputOn returns the state of an output in status.
+func outputOn(t *testing.T, status *netio.Status, id int) bool {
+	t.Helper()
+	o, ok := status.Output(id)
+	if !ok {
+		t.Fatalf("output %d missing from status", id)
+	}
+	return o.On()
+}
+
+func TestActions(t *testing.T) {
+	sim := newSim(t, netiosim.Config{On: []int{2}, Delay: 100 * time.Millisecond})
+	c := netio.NewClient(sim.URL(), "netio", "secret")
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputOn(t, status, 1) || !outputOn(t, status, 2) {
+		t.Fatalf("got outputs %+v, want only 2 on", status.Outputs)
+	}
+
+	tests := []struct {
+		output int
+		action netio.Action
+		want   bool
+	}{
+		{1, netio.ActionOn, true},
+		{1, netio.ActionOn, true},
+		{1, netio.ActionOff, false},
+		{2, netio.ActionToggle, false},
+		{2, netio.ActionToggle, true},
+		{2, netio.ActionNoChange, true},
+		{3, netio.ActionShortOn, true},
+		{4, netio.ActionShortOff, false},
+	}
+	var want []netiosim.Command
+	for _, tt := range tests {
+		status, err := c.Set(tt.output, tt.action)
+		if err != nil {
+			t.Fatalf("output %d %s: %v", tt.output, tt.action, err)
+		}
+		if got := outputOn(t, status, tt.output); got != tt.want {
+			t.Errorf("output %d %s: got on %v, want %v", tt.output, tt.action, got, tt.want)
+		}
+		want = append(want, netiosim.Command{Output: tt.output, Action: tt.action})
+	}
+	if got := sim.Received(); !slices.Equal(got, want) {
+		t.Errorf("device received %v, want %v", got, want)
+	}
+
+	// Short actions revert after the output's delay
+	time.Sleep(300 * time.Millisecond)
+	status, err = c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputOn(t, status, 3) || !outputOn(t, status, 4) {
+		t.Errorf("got outputs %+v after the short actions, want 3 off and 4 on", status.Outputs)
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	sim := newSim(t, netiosim.Config{})
+	c := netio.NewClient(sim.URL(), "netio", "wrong")
+	c.Retries = 2
+
+	_, err := c.Set(1, netio.ActionOn)
+	var statusErr *httpaction.StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want status 401", err)
+	}
+	if got := sim.Received(); len(got) != 0 {
+		t.Errorf("device received %v without authentication", got)
+	}
+	if outputOn(t, sim.Status(), 1) {
+		t.Error("output 1 switched on without authentication")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	sim := newSim(t, netiosim.Config{})
+	c := netio.NewClient(sim.URL(), "netio", "secret")
+	c.Retries = 2
+
+	sim.InjectError(2, http.StatusServiceUnavailable)
+	status, err := c.Set(1, netio.ActionOn)
+	if err != nil {
+		t.Fatalf("switching on after 2 failures: %v", err)
+	}
+	if !outputOn(t, status, 1) {
+		t.Error("output 1 not on after retrying")
+	}
+
+	sim.InjectError(3, http.StatusServiceUnavailable)
+	if _, err := c.Set(1, netio.ActionOff); err == nil {
+		t.Fatal("switching off after 3 failures succeeded, want an error")
+	}
+	if !outputOn(t, sim.Status(), 1) {
+		t.Error("output 1 switched off although every attempt failed")
+	}
+}
+
+func TestToggleNotRetried(t *testing.T) {
+	sim := newSim(t, netiosim.Config{})
+	c := netio.NewClient(sim.URL(), "netio", "secret")
+	c.Retries = 2
+
+	for _, action := range []netio.Action{netio.ActionToggle, netio.ActionShortOn, netio.ActionShortOff} {
+		sim.InjectError(1, http.StatusServiceUnavailable)
+		if _, err := c.Set(1, action); err == nil {
+			t.Errorf("%s: got no error, want the first failure", action)
+		}
+	}
+	if got := sim.Received(); len(got) != 0 {
+		t.Errorf("device received %v, want no retries", got)
+	}
+	if outputOn(t, sim.Status(), 1) {
+		t.Error("output 1 switched although every request failed")
+	}
+}
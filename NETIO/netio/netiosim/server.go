@@ -0,0 +1,366 @@
+// Package netiosim provides a fake NETIO power socket that serves the JSON
+// API (netio.json), for running the NETIO tool and its tests without a
+// device.
+//
+// Outputs switch like on a real device, including short on and short off,
+// and outputs that are on draw their configured load, so the metering
+// values change with the output states.
+package netiosim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"NETIO/netio"
+)
+
+// Config sets up the simulated device.
+type Config struct {
+	Model        string // Defaults to "NETIO 4All"
+	DeviceName   string // Defaults to "NETIO Simulator"
+	SerialNumber string // Defaults to "24A42C000000"
+	Outputs      int    // Defaults to 4
+
+	// Username and Password are required with basic authentication.
+	// Without a username every request is accepted.
+	Username string
+	Password string
+
+	// OutputNames default to "output_1", "output_2" and so on.
+	OutputNames []string
+
+	// On lists the outputs, counting from 1, that are on at the start.
+	On []int
+
+	// Loads are the power drawn by the outputs while on, in W. Missing
+	// entries draw nothing.
+	Loads []float64
+
+	// Delay is the duration of short on and short off, defaulting to 5s.
+	Delay time.Duration
+
+	// Latency delays every reply.
+	Latency time.Duration
+}
+
+// Command is an output action received in a POST.
+type Command struct {
+	Output int
+	Action netio.Action
+}
+
+// Server is a simulated NETIO device.
+type Server struct {
+	ln    net.Listener
+	srv   *http.Server
+	cfg   Config
+	start time.Time
+
+	mu       sync.Mutex
+	outputs  []netio.Output
+	loads    []float64
+	metered  time.Time // Last time the energy counters were updated
+	shorts   []*time.Timer
+	received []Command
+	latency  time.Duration
+	failures int // Requests left to fail
+	failWith int // Status code of failed requests
+}
+
+// New starts a simulated device listening on addr, e.g. "127.0.0.1:0" for
+// a random port.
+func New(addr string, cfg Config) (*Server, error) {
+	if cfg.Model == "" {
+		cfg.Model = "NETIO 4All"
+	}
+	if cfg.DeviceName == "" {
+		cfg.DeviceName = "NETIO Simulator"
+	}
+	if cfg.SerialNumber == "" {
+		cfg.SerialNumber = "24A42C000000"
+	}
+	if cfg.Outputs <= 0 {
+		cfg.Outputs = 4
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = 5 * time.Second
+	}
+
+	now := time.Now()
+	s := &Server{
+		cfg:     cfg,
+		start:   now,
+		outputs: make([]netio.Output, cfg.Outputs),
+		loads:   make([]float64, cfg.Outputs),
+		metered: now,
+		shorts:  make([]*time.Timer, cfg.Outputs),
+		latency: cfg.Latency,
+	}
+	for i := range s.outputs {
+		o := &s.outputs[i]
+		o.ID = i + 1
+		o.Name = fmt.Sprintf("output_%d", i+1)
+		if i < len(cfg.OutputNames) {
+			o.Name = cfg.OutputNames[i]
+		}
+		o.Action = 6
+		o.Delay = int(cfg.Delay / time.Millisecond)
+		o.PowerFactor = 1
+		if i < len(cfg.Loads) {
+			s.loads[i] = cfg.Loads[i]
+		}
+	}
+	for _, id := range cfg.On {
+		if id >= 1 && id <= cfg.Outputs {
+			s.outputs[id-1].State = 1
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+	s.srv = &http.Server{Handler: s}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// Addr returns the address the device listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// URL returns the URL of the JSON API.
+func (s *Server) URL() string {
+	return "http://" + s.Addr() + "/netio.json"
+}
+
+// Close stops the device.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for _, t := range s.shorts {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	s.mu.Unlock()
+	return s.srv.Close()
+}
+
+// SetLatency changes the delay before every reply.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// InjectError makes the next n requests fail with an HTTP status, e.g.
+// http.StatusServiceUnavailable, without touching the outputs.
+func (s *Server) InjectError(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+	s.failWith = status
+}
+
+// Received returns every output action clients have sent, in order.
+func (s *Server) Received() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.received...)
+}
+
+// Status returns what a GET of netio.json would return.
+func (s *Server) Status() *netio.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status()
+}
+
+// Switch turns an output on or off as if done by the button on the
+// device.
+func (s *Server) Switch(output int, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := output - 1; i >= 0 && i < len(s.outputs) {
+		s.meter()
+		s.stopShort(i)
+		s.outputs[i].State = state(on)
+	}
+}
+
+// SetLoad changes the power drawn by an output while it is on, in W.
+func (s *Server) SetLoad(output int, load float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := output - 1; i >= 0 && i < len(s.loads) {
+		s.meter()
+		s.loads[i] = load
+	}
+}
+
+// ServeHTTP answers requests to the JSON API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if r.URL.Path != "/netio.json" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.cfg.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != s.cfg.Username || password != s.cfg.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="netio"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, http.StatusText(s.failWith), s.failWith)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Outputs []struct {
+				ID     int
+				Action *netio.Action
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		// Check all actions before changing anything
+		for _, o := range req.Outputs {
+			if o.ID < 1 || o.ID > len(s.outputs) || o.Action == nil || *o.Action < netio.ActionOff || *o.Action > netio.ActionNoChange {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+		}
+		s.meter()
+		for _, o := range req.Outputs {
+			s.received = append(s.received, Command{Output: o.ID, Action: *o.Action})
+			s.apply(o.ID-1, *o.Action)
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.status())
+}
+
+// apply carries out an action on output i. The caller must hold s.mu.
+func (s *Server) apply(i int, action netio.Action) {
+	o := &s.outputs[i]
+	switch action {
+	case netio.ActionOff, netio.ActionOn:
+		s.stopShort(i)
+		o.State = int(action)
+	case netio.ActionToggle:
+		s.stopShort(i)
+		o.State = 1 - o.State
+	case netio.ActionShortOff, netio.ActionShortOn:
+		// A new short action replaces a running one
+		on := action == netio.ActionShortOn
+		s.stopShort(i)
+		o.State = state(on)
+		var t *time.Timer
+		t = time.AfterFunc(s.cfg.Delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.shorts[i] != t {
+				// Stopped while waiting for the lock
+				return
+			}
+			s.meter()
+			s.shorts[i] = nil
+			o.State = state(!on)
+		})
+		s.shorts[i] = t
+	}
+}
+
+// stopShort cancels a running short action. The caller must hold s.mu.
+func (s *Server) stopShort(i int) {
+	if s.shorts[i] != nil {
+		s.shorts[i].Stop()
+		s.shorts[i] = nil
+	}
+}
+
+// meter adds the energy used since the last call to the counters. It must
+// be called before any change to the outputs or loads. The caller must
+// hold s.mu.
+func (s *Server) meter() {
+	now := time.Now()
+	hours := now.Sub(s.metered).Hours()
+	s.metered = now
+	for i := range s.outputs {
+		if s.outputs[i].On() {
+			s.outputs[i].Energy += s.loads[i] * hours
+		}
+	}
+}
+
+// status returns the device status. The caller must hold s.mu.
+func (s *Server) status() *netio.Status {
+	const voltage = 230.0
+
+	s.meter()
+	st := &netio.Status{
+		Agent: netio.Agent{
+			Model:        s.cfg.Model,
+			Version:      "3.0.0",
+			JSONVer:      "2.1",
+			DeviceName:   s.cfg.DeviceName,
+			SerialNumber: s.cfg.SerialNumber,
+			Uptime:       int(time.Since(s.start).Seconds()),
+			Time:         time.Now().UTC().Format("2006-01-02T15:04:05-07:00"),
+			NumOutputs:   len(s.outputs),
+		},
+		GlobalMeasure: netio.GlobalMeasure{
+			Voltage:            voltage,
+			Frequency:          50,
+			OverallPowerFactor: 1,
+			EnergyStart:        s.start.UTC().Format("2006-01-02T15:04:05-07:00"),
+		},
+		Outputs: append([]netio.Output(nil), s.outputs...),
+	}
+	for i := range st.Outputs {
+		o := &st.Outputs[i]
+		if o.On() {
+			o.Load = s.loads[i]
+			o.Current = s.loads[i] / voltage * 1000
+		}
+		st.GlobalMeasure.TotalLoad += o.Load
+		st.GlobalMeasure.TotalCurrent += o.Current
+		st.GlobalMeasure.TotalEnergy += o.Energy
+	}
+	return st
+}
+
+func state(on bool) int {
+	if on {
+		return 1
+	}
+	return 0
+}
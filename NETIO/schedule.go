@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runSchedule applies the action of a schedule whenever it is due.
+func runSchedule(s *Schedule) {
+	name := s.Name
+	if name == "" {
+		name = s.Cron
+	}
+
+	for {
+		next := s.schedule.Next(time.Now())
+		if next.IsZero() {
+			fmt.Printf("Schedule %s has no more dates\n", name)
+			return
+		}
+		time.Sleep(time.Until(next))
+
+		// Skip the date if the clock was changed while sleeping
+		if !s.schedule.Matches(time.Now()) {
+			continue
+		}
+		fmt.Printf("Schedule %s is due\n", name)
+		if err := setOutput(&s.Switch); err != nil {
+			fmt.Println("Error", err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"rawpanel"
+)
+
+// countdown is a running timer.
+type countdown struct {
+	deadline time.Time
+	cancel   chan struct{}
+}
+
+var (
+	timerMu    sync.Mutex
+	holding    = make(map[*Timer]*time.Timer) // Buttons held down, until the hold time has passed
+	countdowns = make(map[*Timer]*countdown)
+)
+
+// handleTimer starts a timer when its button is held long enough and
+// cancels a running one when it is pressed.
+func handleTimer(t *Timer, ev rawpanel.Event) {
+	if ev.HWC != t.HWC || (t.Edge != 0 && int(ev.Edge) != t.Edge) {
+		return
+	}
+
+	timerMu.Lock()
+	defer timerMu.Unlock()
+	switch ev.Trigger {
+	case rawpanel.TriggerDown:
+		if c, ok := countdowns[t]; ok {
+			close(c.cancel)
+			delete(countdowns, t)
+			fmt.Printf("Timer for %s output %d cancelled\n", cfg.deviceName(t.device), t.Output)
+			go showTimers(t.device)
+			return
+		}
+		holding[t] = time.AfterFunc(t.hold(), func() { startTimer(t) })
+	case rawpanel.TriggerUp:
+		if h, ok := holding[t]; ok {
+			h.Stop()
+			delete(holding, t)
+		}
+	}
+}
+
+// startTimer starts the countdown of a timer whose button was held.
+func startTimer(t *Timer) {
+	timerMu.Lock()
+	defer timerMu.Unlock()
+	if _, ok := holding[t]; !ok {
+		// Released just as the hold time ran out
+		return
+	}
+	delete(holding, t)
+
+	c := &countdown{
+		deadline: time.Now().Add(time.Duration(t.Delay) * time.Second),
+		cancel:   make(chan struct{}),
+	}
+	countdowns[t] = c
+	fmt.Printf("%s output %d switches %s in %d s\n", cfg.deviceName(t.device), t.Output, t.action, t.Delay)
+	go runCountdown(t, c)
+}
+
+// runCountdown shows the remaining time of a timer every second and
+// applies its action when the time is up.
+func runCountdown(t *Timer, c *countdown) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		remaining := time.Until(c.deadline)
+		if remaining <= 0 {
+			break
+		}
+		send(rawpanel.NewState(t.HWC).Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorAmber).Text(timerText(t, remaining)))
+		select {
+		case <-ticker.C:
+		case <-c.cancel:
+			return
+		}
+	}
+
+	timerMu.Lock()
+	if countdowns[t] != c {
+		// Cancelled at the last moment
+		timerMu.Unlock()
+		return
+	}
+	delete(countdowns, t)
+	timerMu.Unlock()
+
+	if err := setOutput(&t.Switch); err != nil {
+		fmt.Println("Error", err)
+		flashError(t.HWC, func() { showTimers(t.device) })
+		return
+	}
+	// setOutput has shown the timer as idle again
+}
+
+// isCounting reports whether a timer is running.
+func isCounting(t *Timer) bool {
+	timerMu.Lock()
+	defer timerMu.Unlock()
+	_, ok := countdowns[t]
+	return ok
+}
+
+// showTimers shows the buttons and timers of a device with the last known
+// status.
+func showTimers(device int) {
+	statusMu.Lock()
+	status := statuses[device]
+	statusMu.Unlock()
+	showOutputs(device, status)
+}
+
+// timerText returns the display text of a timer with the remaining time,
+// rounded up to the second.
+func timerText(t *Timer, remaining time.Duration) rawpanel.HWCText {
+	seconds := int((remaining + time.Second - 1) / time.Second)
+	return rawpanel.HWCText{
+		Formatting: 7,
+		Title:      fmt.Sprintf("Output %d", t.Output),
+		Textline1:  fmt.Sprintf("%s in", t.action),
+		Textline2:  fmt.Sprintf("%d:%02d", seconds/60, seconds%60),
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"Picsum/source"
+)
+
+// Retry delays of a worker whose fetch failed.
+const (
+	minRetryDelay = 500 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+)
+
+// ImageBuffer holds images fetched ahead of time, so a button press never
+// waits for the image source. Workers refill it in the background; every
+// image taken out frees a slot for the next fetch, so no more than the
+// buffer size are held or being fetched at once.
+//
+// Images taken out are remembered. When the buffer runs empty, e.g. while
+// the source is unreachable, they are shown again in turn.
+type ImageBuffer struct {
+	source source.Source
+	slots  chan struct{}      // One token per slot free to be fetched into
+	ready  chan *source.Image // Fetched images, oldest first
+
+	mu       sync.Mutex
+	cache    []*source.Image // Recently taken images, for falling back
+	cacheAt  int             // Next cache entry to replace
+	fallback int             // Next cache entry to fall back to
+	stats    BufferStats
+	failing  bool
+}
+
+// BufferStats describes the state of an ImageBuffer.
+type BufferStats struct {
+	Size      int // Capacity
+	Ready     int // Images waiting to be shown
+	Fetching  int // Fetches in progress
+	Fetched   int // Images fetched since start
+	Failed    int // Failed fetches since start
+	Fallbacks int // Cached images shown because the buffer was empty
+}
+
+func (s BufferStats) String() string {
+	return fmt.Sprintf("%d/%d ready, %d fetching, %d fetched, %d failed, %d fallbacks",
+		s.Ready, s.Size, s.Fetching, s.Fetched, s.Failed, s.Fallbacks)
+}
+
+// NewImageBuffer returns a buffer of size images fetched from src.
+func NewImageBuffer(src source.Source, size int) *ImageBuffer {
+	ib := &ImageBuffer{
+		source: src,
+		slots:  make(chan struct{}, size),
+		ready:  make(chan *source.Image, size),
+		cache:  make([]*source.Image, 0, size),
+	}
+	ib.stats.Size = size
+	for i := 0; i < size; i++ {
+		ib.slots <- struct{}{}
+	}
+	return ib
+}
+
+// Start starts workers goroutines that keep the buffer filled.
+func (ib *ImageBuffer) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		go ib.work()
+	}
+}
+
+// Take returns the next image without waiting. fresh is false if the
+// buffer was empty and a cached image is shown again; the image is nil if
+// nothing was fetched yet.
+func (ib *ImageBuffer) Take() (img *source.Image, fresh bool) {
+	select {
+	case img = <-ib.ready:
+		ib.slots <- struct{}{}
+	default:
+	}
+
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if img == nil {
+		if len(ib.cache) == 0 {
+			return nil, false
+		}
+		ib.fallback %= len(ib.cache)
+		img = ib.cache[ib.fallback]
+		ib.fallback++
+		ib.stats.Fallbacks++
+		return img, false
+	}
+
+	if len(ib.cache) < cap(ib.cache) {
+		ib.cache = append(ib.cache, img)
+	} else {
+		ib.cache[ib.cacheAt] = img
+		ib.cacheAt = (ib.cacheAt + 1) % len(ib.cache)
+	}
+	return img, true
+}
+
+// Stats returns the current numbers of the buffer.
+func (ib *ImageBuffer) Stats() BufferStats {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	stats := ib.stats
+	stats.Ready = len(ib.ready)
+	return stats
+}
+
+// work fetches an image whenever a slot is free, waiting longer after
+// every failed fetch.
+func (ib *ImageBuffer) work() {
+	delay := minRetryDelay
+	for range ib.slots {
+		ib.mu.Lock()
+		ib.stats.Fetching++
+		ib.mu.Unlock()
+
+		img, err := ib.source.Next()
+
+		ib.mu.Lock()
+		ib.stats.Fetching--
+		// Only report when the source goes away or comes back
+		if err != nil {
+			ib.stats.Failed++
+			if !ib.failing {
+				fmt.Println("Error fetching image:", err)
+			}
+		} else {
+			ib.stats.Fetched++
+			if ib.failing {
+				fmt.Println("Image source available again")
+			}
+		}
+		ib.failing = err != nil
+		ib.mu.Unlock()
+
+		if err != nil {
+			ib.slots <- struct{}{}
+			time.Sleep(delay)
+			delay = min(delay*2, maxRetryDelay)
+			continue
+		}
+		delay = minRetryDelay
+		ib.ready <- img
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"Picsum/convert"
+	"Picsum/source"
+	"rawpanel"
+	"rawpanel/config"
+)
+
+// Config holds the panel address and image settings.
+type Config struct {
+	Panel    string `json:"panel"`    // Raw Panel server, host:port
+	ImageURL string `json:"imageURL"` // Where new images are fetched from, for the url source
+
+	// Source selects where images come from.
+	Source Source `json:"source"`
+
+	// BufferSize is how many images are fetched ahead of time, by up to
+	// Workers fetches at once.
+	BufferSize int `json:"bufferSize"`
+	Workers    int `json:"workers"`
+
+	// MetricsInterval is how often the image buffer numbers are logged, in
+	// ms. 0 turns them off.
+	MetricsInterval int `json:"metricsInterval"`
+
+	// Displays lists the HWC IDs that show an image when pressed. Empty
+	// means every button.
+	Displays []int `json:"displays"`
+
+	// DisplayFormats set the resolution and pixel format of displays the
+	// panel topology does not describe, or describes wrongly.
+	DisplayFormats []DisplayFormat `json:"displayFormats"`
+}
+
+// DisplayFormat is the resolution and pixel format of a display. Unset
+// fields are taken from the panel topology.
+type DisplayFormat struct {
+	HWC    int    `json:"hwc"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Mode   string `json:"mode"` // mono, gray or rgb16
+
+	mode convert.Mode
+}
+
+// Source selects where images come from.
+type Source struct {
+	// Type is one of
+	//	url     fetch ImageURL for every image (default)
+	//	urls    fetch URLs in turn
+	//	folder  show the images in Path in turn
+	//	file    always show the image at Path
+	Type string `json:"type"`
+
+	URLs []string `json:"urls"`
+	Path string   `json:"path"`
+
+	// Recursive includes the subfolders of a folder.
+	Recursive bool `json:"recursive"`
+
+	// WatchInterval is how often a folder is scanned for new and removed
+	// images, in ms.
+	WatchInterval int `json:"watchInterval"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Panel:    "192.168.11.166:9923",
+		ImageURL: "https://picsum.photos/536/354",
+		Source: Source{
+			Type:          "url",
+			WatchInterval: 2000,
+		},
+		BufferSize:      5,
+		Workers:         2,
+		MetricsInterval: 60000,
+	}
+}
+
+// loadConfig reads the config file given by -config or PICSUM_CONFIG, then
+// applies environment variables and flags on top.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("Picsum", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("PICSUM_CONFIG"), "JSON config file")
+	panel := fs.String("panel", "", "Raw Panel server address, host:port (env PICSUM_PANEL)")
+	imageURL := fs.String("image-url", "", "image URL (env PICSUM_IMAGE_URL)")
+	imageDir := fs.String("image-dir", "", "show the images in this folder instead of fetching them (env PICSUM_IMAGE_DIR)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := config.Load(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	config.Override(&cfg.Panel, os.Getenv("PICSUM_PANEL"), *panel)
+	config.Override(&cfg.ImageURL, os.Getenv("PICSUM_IMAGE_URL"), *imageURL)
+	if dir := os.Getenv("PICSUM_IMAGE_DIR"); dir != "" || *imageDir != "" {
+		cfg.Source.Type = "folder"
+		config.Override(&cfg.Source.Path, dir, *imageDir)
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	return errors.Join(
+		config.CheckAddr("panel", cfg.Panel),
+		cfg.validateSource(),
+		cfg.validateBuffer(),
+		cfg.validateFormats(),
+		config.CheckHWCs(cfg.hwcs()),
+	)
+}
+
+func (cfg *Config) validateBuffer() error {
+	var errs []error
+	if cfg.BufferSize < 1 {
+		errs = append(errs, fmt.Errorf("bufferSize: invalid size %d", cfg.BufferSize))
+	}
+	if cfg.Workers < 1 || cfg.Workers > cfg.BufferSize {
+		errs = append(errs, fmt.Errorf("workers: want 1 to bufferSize, got %d", cfg.Workers))
+	}
+	if cfg.MetricsInterval < 0 {
+		errs = append(errs, fmt.Errorf("metricsInterval: invalid interval %d", cfg.MetricsInterval))
+	}
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) validateFormats() error {
+	var errs []error
+	used := make(map[int]int)
+	for i := range cfg.DisplayFormats {
+		f := &cfg.DisplayFormats[i]
+		if f.HWC < 1 {
+			errs = append(errs, fmt.Errorf("displayFormats[%d].hwc: invalid HWC ID %d", i, f.HWC))
+		}
+		if other, ok := used[f.HWC]; ok {
+			errs = append(errs, fmt.Errorf("displayFormats[%d].hwc: HWC ID %d is already used by displayFormats[%d]", i, f.HWC, other))
+		}
+		used[f.HWC] = i
+		if f.Width < 0 || f.Height < 0 {
+			errs = append(errs, fmt.Errorf("displayFormats[%d]: invalid size %dx%d", i, f.Width, f.Height))
+		}
+		if f.Mode != "" {
+			mode, err := convert.ParseMode(f.Mode)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("displayFormats[%d].mode: invalid mode %q", i, f.Mode))
+			}
+			f.mode = mode
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) validateSource() error {
+	s := &cfg.Source
+	switch s.Type {
+	case "url", "":
+		return config.CheckURL("imageURL", cfg.ImageURL)
+	case "urls":
+		if len(s.URLs) == 0 {
+			return errors.New("source.urls: no URLs")
+		}
+		var errs []error
+		for i, u := range s.URLs {
+			errs = append(errs, config.CheckURL(fmt.Sprintf("source.urls[%d]", i), u))
+		}
+		return errors.Join(errs...)
+	case "folder", "file":
+		info, err := os.Stat(s.Path)
+		switch {
+		case s.Path == "":
+			return errors.New("source.path: path is required")
+		case err != nil:
+			return fmt.Errorf("source.path: %w", err)
+		case s.Type == "folder" && !info.IsDir():
+			return fmt.Errorf("source.path: %s is not a folder", s.Path)
+		case s.Type == "file" && info.IsDir():
+			return fmt.Errorf("source.path: %s is a folder", s.Path)
+		case s.Type == "folder" && s.WatchInterval <= 0:
+			return fmt.Errorf("source.watchInterval: invalid interval %d", s.WatchInterval)
+		}
+		return nil
+	}
+	return fmt.Errorf("source.type: unknown source %q", s.Type)
+}
+
+// newSource opens the configured image source.
+func (cfg *Config) newSource() (source.Source, error) {
+	s := &cfg.Source
+	switch s.Type {
+	case "urls":
+		return source.URLList(s.URLs), nil
+	case "folder":
+		interval := time.Duration(s.WatchInterval) * time.Millisecond
+		return source.Folder(s.Path, s.Recursive, interval, func(err error) {
+			fmt.Println("Error scanning image folder:", err)
+		})
+	case "file":
+		return source.File(s.Path), nil
+	}
+	return source.URL(cfg.ImageURL), nil
+}
+
+// hwcs returns the configured HWC IDs by setting name.
+func (cfg *Config) hwcs() map[string]int {
+	hwcs := make(map[string]int)
+	for i, hwc := range cfg.Displays {
+		hwcs[fmt.Sprintf("displays[%d]", i)] = hwc
+	}
+	return hwcs
+}
+
+// isDisplay reports whether pressing hwc should show an image.
+func (cfg *Config) isDisplay(hwc int) bool {
+	if len(cfg.Displays) == 0 {
+		return true
+	}
+	for _, id := range cfg.Displays {
+		if id == hwc {
+			return true
+		}
+	}
+	return false
+}
+
+// displayFormat returns the resolution and pixel format of the display of
+// hwc: from DisplayFormats, else from the panel topology, else the
+// defaults. Displays the topology does not describe get color images.
+func (cfg *Config) displayFormat(hwc int, topology *rawpanel.Topology) (w, h int, mode convert.Mode) {
+	w, h, mode = imageWidth, imageHeight, convert.RGB16
+	if d := topology.Display(hwc); d != nil {
+		w, h = d.W, d.H
+		switch {
+		case d.Color():
+			mode = convert.RGB16
+		case d.Gray():
+			mode = convert.Gray
+		default:
+			mode = convert.Mono
+		}
+	}
+	for _, f := range cfg.DisplayFormats {
+		if f.HWC != hwc {
+			continue
+		}
+		if f.Width > 0 {
+			w = f.Width
+		}
+		if f.Height > 0 {
+			h = f.Height
+		}
+		if f.Mode != "" {
+			mode = f.mode
+		}
+	}
+	return w, h, mode
+}
@@ -0,0 +1,143 @@
+// Package convert prepares images for the displays of SKAARHOJ panels:
+// scaled and cropped to the display resolution and reduced to what the
+// display can show, so the panel does not have to guess.
+package convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// Mode is the pixel format of a display.
+type Mode int
+
+const (
+	Mono  Mode = iota // 1-bit black and white, e.g. small OLEDs
+	Gray              // 16 shades of gray
+	RGB16             // 16-bit color, 5 bits red, 6 green, 5 blue
+)
+
+var modeNames = map[Mode]string{
+	Mono:  "mono",
+	Gray:  "gray",
+	RGB16: "rgb16",
+}
+
+func (m Mode) String() string {
+	if name, ok := modeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode returns the mode named name: "mono", "gray" or "rgb16".
+func ParseMode(name string) (Mode, error) {
+	for m, n := range modeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("convert: unknown mode %q", name)
+}
+
+// imageTypes maps the modes to the Raw Panel GfxConv image types, which are
+// numbered differently.
+var imageTypes = map[Mode]int{
+	Mono:  0,
+	RGB16: 1,
+	Gray:  2,
+}
+
+// ImageType returns the Raw Panel GfxConv image type of the mode.
+func (m Mode) ImageType() int {
+	return imageTypes[m]
+}
+
+var (
+	monoPalette = color.Palette{color.Black, color.White}
+	grayPalette = func() color.Palette {
+		p := make(color.Palette, 16)
+		for i := range p {
+			p[i] = color.Gray{Y: uint8(i * 17)}
+		}
+		return p
+	}()
+)
+
+// Image scales img to cover w x h, crops what sticks out on either side
+// and reduces the colors to mode. Mono and Gray are dithered, so gradients
+// and photos keep their shading.
+func Image(img image.Image, w, h int, mode Mode) image.Image {
+	img = Fill(img, w, h)
+	switch mode {
+	case Mono:
+		return dither(img, monoPalette)
+	case Gray:
+		return dither(img, grayPalette)
+	}
+	return rgb16(img)
+}
+
+// Fill scales img to cover w x h, keeping its aspect ratio, and crops the
+// middle.
+func Fill(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	// Scale by the larger factor, so both sides are at least w and h
+	sw, sh := w, b.Dy()*w/b.Dx()
+	if sh < h {
+		sw, sh = b.Dx()*h/b.Dy(), h
+	}
+	scaled := resize.Resize(uint(sw), uint(sh), img, resize.Lanczos3)
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	offset := scaled.Bounds().Min.Add(image.Pt((sw-w)/2, (sh-h)/2))
+	draw.Draw(out, out.Bounds(), scaled, offset, draw.Src)
+	return out
+}
+
+// dither reduces img to the colors of palette with Floyd-Steinberg error
+// diffusion.
+func dither(img image.Image, palette color.Palette) image.Image {
+	// Dither the luminance, so colors turn into the gray they look like
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	draw.Draw(gray, b, img, b.Min, draw.Src)
+
+	out := image.NewPaletted(b, palette)
+	draw.FloydSteinberg.Draw(out, b, gray, b.Min)
+	return out
+}
+
+// rgb16 rounds the colors of img to 5 bits of red and blue and 6 bits of
+// green.
+func rgb16(img image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			out.Set(x, y, color.RGBA{
+				R: round(r>>8, 5),
+				G: round(g>>8, 6),
+				B: round(bl>>8, 5),
+				A: 0xff,
+			})
+		}
+	}
+	return out
+}
+
+// round rounds an 8-bit value to the nearest value with the given number of
+// bits and scales it back to 8 bits.
+func round(v uint32, bits uint) uint8 {
+	max := uint32(1)<<bits - 1
+	q := (v*max + 127) / 255
+	return uint8(q * 255 / max)
+}
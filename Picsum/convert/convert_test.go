@@ -0,0 +1,20 @@
+package convert
+
+import "testing"
+
+func TestImageType(t *testing.T) {
+	// The GfxConv image types of the Raw Panel protocol
+	tests := []struct {
+		mode Mode
+		want int
+	}{
+		{Mono, 0},
+		{RGB16, 1},
+		{Gray, 2},
+	}
+	for _, tt := range tests {
+		if got := tt.mode.ImageType(); got != tt.want {
+			t.Errorf("%s: got image type %d, want %d", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -2,4 +2,8 @@ module Picsum
 
 go 1.21.0
 
-require github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646 // indirect
+require github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+
+require rawpanel v0.0.0
+
+replace rawpanel => ../rawpanel
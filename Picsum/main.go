@@ -1,155 +1,116 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
-	"net"
-	"net/http"
 	"os"
-	"strconv"
+	"path"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/nfnt/resize"
+	"Picsum/convert"
+	"rawpanel"
 )
 
+// Size used for displays the panel topology does not describe
 const (
-	serverAddr  = "192.168.11.166:9923"
-	initialCmd  = "list\n"
-	imageUrl    = "https://picsum.photos/536/354"
 	imageWidth  = 96
 	imageHeight = 64
-	imageType   = 1
-	bufferSize  = 5
 )
 
-type ImageBuffer struct {
-	buffer  [][]byte
-	current int
-	mutex   sync.Mutex
-}
-
-func NewImageBuffer() *ImageBuffer {
-	return &ImageBuffer{
-		buffer:  make([][]byte, bufferSize),
-		current: 0,
-	}
-}
-
-func (ib *ImageBuffer) AddImage(image []byte) {
-	ib.mutex.Lock()
-	defer ib.mutex.Unlock()
-
-	ib.buffer[ib.current] = image
-	ib.current = (ib.current + 1) % bufferSize
-}
-
-func (ib *ImageBuffer) GetNextImage() []byte {
-	ib.mutex.Lock()
-	defer ib.mutex.Unlock()
-
-	return ib.buffer[ib.current]
-}
+var cfg *Config
 
 func main() {
-	imageBuffer := NewImageBuffer()
-
-	// Fetch initial images
-	for i := 0; i < bufferSize; i++ {
-		image, err := fetchAndScaleImage(imageUrl, imageWidth, imageHeight)
-		if err != nil {
-			fmt.Println("Error fetching or scaling image:", err)
-			os.Exit(1)
-		}
-		imageBuffer.AddImage(image)
-	}
-
-	// Connect to the server
-	conn, err := net.Dial("tcp", serverAddr)
+	// Load the configuration from file, environment and flags
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println("Error connecting to the server:", err)
-		os.Exit(1)
+		fmt.Println("Error loading configuration:", err)
+		os.Exit(2)
 	}
-	defer conn.Close()
 
-	// Send the initial command
-	_, err = conn.Write([]byte(initialCmd))
+	images, err := cfg.newSource()
 	if err != nil {
-		fmt.Println("Error sending initial command:", err)
+		fmt.Println("Error opening image source:", err)
 		os.Exit(1)
 	}
+	// Fetch images in the background so presses never wait for the source
+	imageBuffer := NewImageBuffer(images, cfg.BufferSize)
+	imageBuffer.Start(cfg.Workers)
+	if cfg.MetricsInterval > 0 {
+		go logStats(imageBuffer, time.Duration(cfg.MetricsInterval)*time.Millisecond)
+	}
 
-	scanner := bufio.NewScanner(conn)
-
-	for scanner.Scan() {
-		command := scanner.Text()
+	// Connect to the server, reconnecting whenever it goes away. Images
+	// already shown are pushed again after a reconnect.
+	conn := rawpanel.Connect(cfg.Panel, rawpanel.Options{
+		OnStateChange: func(state rawpanel.ConnState) {
+			fmt.Println("Raw Panel server", state)
+		},
+		OnTopology: func(topology *rawpanel.Topology) {
+			if err := topology.CheckHWCs(cfg.hwcs()); err != nil {
+				fmt.Println("Warning:", err)
+			}
+		},
+		OnError: func(err error) {
+			fmt.Println("Skipping malformed event:", err)
+		},
+	})
+	defer conn.Close()
 
+	for ev := range conn.Events() {
 		// Check if the command matches the expected format "HWC#X.Y=Down"
-		if strings.HasSuffix(command, "=Down") {
-			// Parse the command to extract HWCID
-			hwcID := parseHWCID(command)
+		if ev.Trigger == rawpanel.TriggerDown && cfg.isDisplay(ev.HWC) {
+			hwcID := ev.HWC
 			fmt.Println(hwcID)
 
-			// Get the next image from the buffer
-			image := imageBuffer.GetNextImage()
+			next, fresh := imageBuffer.Take()
+			if next == nil {
+				fmt.Println("No image available yet")
+				continue
+			}
+			if !fresh {
+				fmt.Println("Image buffer empty, showing", next.Name, "again")
+			}
+
+			// Fit the next image from the buffer to the display
+			width, height, mode := cfg.displayFormat(hwcID, conn.Topology())
+			image, err := encodeImage(convert.Image(next.Image, width, height, mode), next.Name, mode)
+			if err != nil {
+				fmt.Println("Error encoding image:", err)
+				continue
+			}
 
 			// Create a JSON package
-			jsonData := createJSONPackage(hwcID, image)
+			jsonData := createJSONPackage(hwcID, image, width, height, mode)
 
 			// Send the JSON package to the server
-			_, err := conn.Write(jsonData)
+			err = conn.Send(jsonData)
 			if err != nil {
 				fmt.Println("Error sending JSON package:", err)
-				os.Exit(1)
-			}
-
-			// Fetch and replace the used image in the buffer
-			newImage, err := fetchAndScaleImage(imageUrl, imageWidth, imageHeight)
-			if err != nil {
-				fmt.Println("Error fetching or scaling new image:", err)
-				os.Exit(1)
 			}
-			imageBuffer.AddImage(newImage)
 		}
 	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading from server:", err)
-	}
 }
 
-func fetchAndScaleImage(url string, width, height int) ([]byte, error) {
-	response, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// logStats prints the numbers of the image buffer every interval.
+func logStats(ib *ImageBuffer, interval time.Duration) {
+	for range time.Tick(interval) {
+		fmt.Println("Image buffer:", ib.Stats())
 	}
-	defer response.Body.Close()
-
-	img, _, err := image.Decode(response.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	scaledImg := resize.Resize(uint(width), uint(height), img, resize.Lanczos3)
+}
 
-	var buf []byte
-	if strings.HasSuffix(url, ".jpg") || strings.HasSuffix(url, ".jpeg") {
-		buf, err = encodeToJPEG(scaledImg)
-	} else {
-		buf, err = encodeToPNG(scaledImg)
+// encodeImage encodes a converted image as PNG, or as JPEG for color
+// displays if it was loaded from a JPEG file or URL.
+func encodeImage(img image.Image, name string, mode convert.Mode) ([]byte, error) {
+	if ext := strings.ToLower(path.Ext(name)); mode == convert.RGB16 && (ext == ".jpg" || ext == ".jpeg") {
+		return encodeToJPEG(img)
 	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	return buf, nil
+	return encodeToPNG(img)
 }
 
 func encodeToJPEG(img image.Image) ([]byte, error) {
@@ -172,42 +133,12 @@ func encodeToPNG(img image.Image) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func parseHWCID(command string) int {
-	// Split the command by '#' and '.' to extract the HWC number
-	parts := strings.Split(command, "#")
-	if len(parts) != 2 {
-		return 0
-	}
-
-	hwcPart := parts[1]
-	hwcParts := strings.Split(hwcPart, ".")
-	if len(hwcParts) != 2 {
-		return 0
-	}
-
-	hwcIDStr := hwcParts[0]
-	hwcID, err := strconv.Atoi(hwcIDStr)
-	if err != nil {
-		return 0
-	}
-
-	return hwcID
-}
-
-func createJSONPackage(hwcID int, image []byte) []byte {
-	jsonData := map[string]interface{}{
-		"HWCIDs": []int{hwcID},
-		"Processors": map[string]interface{}{
-			"GfxConv": map[string]interface{}{
-				"W":         imageWidth,
-				"H":         imageHeight,
-				"Scaling":   2,
-				"ImageType": imageType,
-				"ImageData": base64.StdEncoding.EncodeToString(image),
-			},
-		},
-	}
-
-	jsonBytes, _ := json.Marshal(jsonData)
-	return append(jsonBytes, '\n')
+func createJSONPackage(hwcID int, image []byte, width, height int, mode convert.Mode) *rawpanel.State {
+	return rawpanel.NewState(hwcID).Image(rawpanel.GfxConv{
+		W:         width,
+		H:         height,
+		Scaling:   2,
+		ImageType: mode.ImageType(),
+		ImageData: base64.StdEncoding.EncodeToString(image),
+	})
 }
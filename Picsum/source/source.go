@@ -0,0 +1,192 @@
+// Package source provides the images Picsum shows: fetched from a URL,
+// cycled through a list of URLs, or read from a local folder or file.
+package source
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"  // Decoders for image.Decode
+	_ "image/jpeg" //
+	_ "image/png"  //
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Image is an image with where it came from.
+type Image struct {
+	image.Image
+	Name string // URL or file path
+}
+
+// Source hands out images. Next may be called from several goroutines.
+type Source interface {
+	Next() (*Image, error)
+}
+
+// fetchTimeout limits each HTTP request.
+const fetchTimeout = 30 * time.Second
+
+var client = &http.Client{Timeout: fetchTimeout}
+
+// URL returns a source that fetches url for every image, for services like
+// picsum.photos that answer with a new image every time.
+func URL(url string) Source {
+	return URLList([]string{url})
+}
+
+// URLList returns a source that fetches the URLs in turn, starting over
+// after the last one.
+func URLList(urls []string) Source {
+	return &urlList{urls: urls}
+}
+
+type urlList struct {
+	urls []string
+
+	mu   sync.Mutex
+	next int
+}
+
+func (s *urlList) Next() (*Image, error) {
+	s.mu.Lock()
+	url := s.urls[s.next]
+	s.next = (s.next + 1) % len(s.urls)
+	s.mu.Unlock()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return decode(resp.Body, url)
+}
+
+// File returns a source that always shows the image at path. The file is
+// read again for every image, so changes show up on the next press.
+func File(path string) Source {
+	return file(path)
+}
+
+type file string
+
+func (f file) Next() (*Image, error) {
+	return load(string(f))
+}
+
+// Folder returns a source that shows the images in dir in turn, sorted by
+// path, including subfolders if recursive is set. The folder is scanned
+// again every interval, so added and removed files are picked up while
+// running. Scan errors are reported to onError, which may be nil.
+func Folder(dir string, recursive bool, interval time.Duration, onError func(error)) (Source, error) {
+	f := &folder{dir: dir, recursive: recursive}
+	if err := f.scan(); err != nil {
+		return nil, err
+	}
+	go f.watch(interval, onError)
+	return f, nil
+}
+
+type folder struct {
+	dir       string
+	recursive bool
+
+	mu    sync.Mutex
+	paths []string
+	next  int
+}
+
+func (f *folder) Next() (*Image, error) {
+	f.mu.Lock()
+	if len(f.paths) == 0 {
+		f.mu.Unlock()
+		return nil, fmt.Errorf("no images in %s", f.dir)
+	}
+	f.next %= len(f.paths)
+	path := f.paths[f.next]
+	f.next++
+	f.mu.Unlock()
+
+	return load(path)
+}
+
+// watch rescans the folder every interval.
+func (f *folder) watch(interval time.Duration, onError func(error)) {
+	for range time.Tick(interval) {
+		if err := f.scan(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// scan lists the image files in the folder.
+func (f *folder) scan() error {
+	var paths []string
+	err := filepath.WalkDir(f.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != f.dir && (!f.recursive || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isImage(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Carry on after the image shown last, even if files came or went
+	if f.next > 0 && f.next <= len(f.paths) {
+		last := f.paths[f.next-1]
+		f.next = sort.SearchStrings(paths, last)
+		if f.next < len(paths) && paths[f.next] == last {
+			f.next++
+		}
+	}
+	f.paths = paths
+	return nil
+}
+
+// isImage reports whether path has the extension of a supported format.
+func isImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return !strings.HasPrefix(filepath.Base(path), ".")
+	}
+	return false
+}
+
+// load reads an image file.
+func load(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decode(f, path)
+}
+
+func decode(r io.Reader, name string) (*Image, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return &Image{Image: img, Name: name}, nil
+}
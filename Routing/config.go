@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"rawpanel/config"
+)
+
+// Config holds the panel and Videohub settings.
+//
+// The router settings at the top level describe a single Videohub. To
+// control several, list them in Routers instead; the top-level router
+// settings are then ignored.
+type Config struct {
+	Panel string `json:"panel"` // Raw Panel server, host:port
+
+	RouterConfig
+
+	// Routers are the Videohubs controlled from the panel. Without
+	// PageButtons every router has its own bank of buttons.
+	Routers []RouterConfig `json:"routers"`
+
+	// PageButtons select which router the panel controls, one per entry of
+	// Routers. With pages the routers may share buttons.
+	PageButtons []int `json:"pageButtons"`
+
+	// Simulate starts a built-in fake Videohub for every router and
+	// connects to it instead of the Videohub address, to try the panel
+	// without a router.
+	Simulate bool `json:"simulate"`
+
+	// HistoryFile, if set, is where the route history is logged, one JSON
+	// object per line. It is read on startup so Undo works across restarts.
+	HistoryFile string `json:"historyFile"`
+
+	// LabelChars cuts labels to this many characters. If 0, the length is
+	// derived from the display width the panel reports.
+	LabelChars int `json:"labelChars"`
+}
+
+// RouterConfig holds the settings of one Videohub and its buttons.
+type RouterConfig struct {
+	Name     string `json:"name"`     // Shown in messages, defaults to "Video Hub <n>"
+	Videohub string `json:"videohub"` // Videohub Ethernet control, host:port
+
+	// Output is the zero based router output the source buttons route to.
+	// With destination buttons it is the output selected at startup.
+	Output int `json:"output"`
+
+	// SourceButtons maps router inputs to HWC IDs: SourceButtons[0] selects
+	// input 0 (shown as 1 on the router), and so on.
+	SourceButtons []int `json:"sourceButtons"`
+
+	// DestinationButtons maps router outputs to HWC IDs the same way. When
+	// set, the panel works as an XY panel: a destination button selects the
+	// output the source buttons route to.
+	DestinationButtons []int `json:"destinationButtons"`
+
+	// TakeButton, if set, must be pressed to route the preselected source.
+	// Without it source buttons route immediately.
+	TakeButton int `json:"takeButton"`
+
+	// LockButton, if set, takes or releases the router lock on the selected
+	// output. Its LED shows the lock state.
+	LockButton int `json:"lockButton"`
+
+	// UndoButton, if set, routes the source the selected output had before
+	// its last change back. It is lit while there is something to undo.
+	UndoButton int `json:"undoButton"`
+
+	// SourceLabels maps router inputs to the HWC IDs whose displays show the
+	// input labels. Defaults to SourceButtons, for buttons with displays.
+	SourceLabels []int `json:"sourceLabels"`
+
+	// DestinationLabels does the same for output labels and defaults to
+	// DestinationButtons.
+	DestinationLabels []int `json:"destinationLabels"`
+
+	// Salvos are buttons that switch several outputs at once.
+	Salvos []Salvo `json:"salvos"`
+}
+
+// Salvo is a named set of crosspoints recalled by one button.
+type Salvo struct {
+	Name   string  `json:"name"`
+	Button int     `json:"button"` // HWC ID, lit while all routes are in place
+	Routes []Route `json:"routes"`
+}
+
+// Route is a crosspoint with zero based router indexes.
+type Route struct {
+	Output int `json:"output"`
+	Input  int `json:"input"`
+}
+
+// routes returns the crosspoints of s by output.
+func (s *Salvo) routes() map[int]int {
+	routes := make(map[int]int, len(s.Routes))
+	for _, r := range s.Routes {
+		routes[r.Output] = r.Input
+	}
+	return routes
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Panel: "192.168.11.5:9973",
+		RouterConfig: RouterConfig{
+			Videohub:      "192.168.10.61:9990",
+			Output:        2,
+			SourceButtons: []int{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+	}
+}
+
+// loadConfig reads the config file given by -config or ROUTING_CONFIG, then
+// applies environment variables and flags on top.
+func loadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("Routing", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("ROUTING_CONFIG"), "JSON config file")
+	panel := fs.String("panel", "", "Raw Panel server address, host:port (env ROUTING_PANEL)")
+	videohub := fs.String("videohub", "", "Videohub address, host:port, without routers list (env ROUTING_VIDEOHUB)")
+	output := fs.String("output", "", "zero based router output to control, without routers list (env ROUTING_OUTPUT)")
+	historyFile := fs.String("history", "", "route history log file (env ROUTING_HISTORY)")
+	simulate := fs.Bool("simulate", false, "use built-in simulated Videohubs")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		if err := config.Load(*configPath, cfg); err != nil {
+			return nil, err
+		}
+	}
+	config.Override(&cfg.Panel, os.Getenv("ROUTING_PANEL"), *panel)
+	config.Override(&cfg.Videohub, os.Getenv("ROUTING_VIDEOHUB"), *videohub)
+	config.Override(&cfg.HistoryFile, os.Getenv("ROUTING_HISTORY"), *historyFile)
+	if err := config.OverrideInt(&cfg.Output, "output", os.Getenv("ROUTING_OUTPUT"), *output); err != nil {
+		return nil, err
+	}
+	if *simulate {
+		cfg.Simulate = true
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+	errs = append(errs, config.CheckAddr("panel", cfg.Panel))
+	if cfg.LabelChars < 0 {
+		errs = append(errs, fmt.Errorf("labelChars: invalid length %d", cfg.LabelChars))
+	}
+	if len(cfg.PageButtons) > 0 && len(cfg.PageButtons) != len(cfg.Routers) {
+		errs = append(errs, fmt.Errorf("pageButtons: %d buttons for %d routers", len(cfg.PageButtons), len(cfg.Routers)))
+	}
+
+	routers := cfg.routers()
+	for i, r := range routers {
+		errs = append(errs, r.validate(cfg.prefix(i), cfg.Simulate))
+	}
+
+	// Labels usually go to the displays of the buttons, so they are checked
+	// apart from them. Pages may reuse the buttons of other pages.
+	if len(cfg.PageButtons) > 0 {
+		for i, r := range routers {
+			hwcs := r.hwcs(cfg.prefix(i))
+			for page, hwc := range cfg.PageButtons {
+				hwcs[fmt.Sprintf("pageButtons[%d]", page)] = hwc
+			}
+			errs = append(errs,
+				config.CheckHWCs(hwcs),
+				config.CheckHWCs(r.labelHWCs(cfg.prefix(i))),
+			)
+		}
+	} else {
+		errs = append(errs,
+			config.CheckHWCs(cfg.hwcs()),
+			config.CheckHWCs(cfg.labelHWCs()),
+		)
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of one router; prefix names it in errors.
+func (r *RouterConfig) validate(prefix string, simulate bool) error {
+	var errs []error
+	if !simulate {
+		errs = append(errs, config.CheckAddr(prefix+"videohub", r.Videohub))
+	}
+	if r.Output < 0 {
+		errs = append(errs, fmt.Errorf("%soutput: invalid output %d", prefix, r.Output))
+	}
+	if r.TakeButton < 0 {
+		errs = append(errs, fmt.Errorf("%stakeButton: invalid HWC ID %d", prefix, r.TakeButton))
+	}
+	if r.LockButton < 0 {
+		errs = append(errs, fmt.Errorf("%slockButton: invalid HWC ID %d", prefix, r.LockButton))
+	}
+	if r.UndoButton < 0 {
+		errs = append(errs, fmt.Errorf("%sundoButton: invalid HWC ID %d", prefix, r.UndoButton))
+	}
+	for i, salvo := range r.Salvos {
+		errs = append(errs, salvo.validate(fmt.Sprintf("%ssalvos[%d]", prefix, i)))
+	}
+	return errors.Join(errs...)
+}
+
+// routers returns the routers to control: the Routers list, or else the
+// top-level router settings.
+func (cfg *Config) routers() []*RouterConfig {
+	if len(cfg.Routers) == 0 {
+		return []*RouterConfig{&cfg.RouterConfig}
+	}
+	routers := make([]*RouterConfig, len(cfg.Routers))
+	for i := range cfg.Routers {
+		routers[i] = &cfg.Routers[i]
+	}
+	return routers
+}
+
+// prefix returns the setting name prefix of router i.
+func (cfg *Config) prefix(i int) string {
+	if len(cfg.Routers) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("routers[%d].", i)
+}
+
+// routerName returns the name of router i for messages.
+func (cfg *Config) routerName(i int) string {
+	if name := cfg.routers()[i].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("Video Hub %d", i+1)
+}
+
+func (s *Salvo) validate(name string) error {
+	var errs []error
+	if s.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name: missing", name))
+	}
+	if len(s.Routes) == 0 {
+		errs = append(errs, fmt.Errorf("%s.routes: no routes", name))
+	}
+	outputs := make(map[int]bool)
+	for i, r := range s.Routes {
+		if r.Output < 0 || r.Input < 0 {
+			errs = append(errs, fmt.Errorf("%s.routes[%d]: invalid route %d to %d", name, i, r.Input, r.Output))
+		}
+		if outputs[r.Output] {
+			errs = append(errs, fmt.Errorf("%s.routes[%d]: output %d is routed twice", name, i, r.Output))
+		}
+		outputs[r.Output] = true
+	}
+	return errors.Join(errs...)
+}
+
+// salvo returns the salvo recalled by a button.
+func (r *RouterConfig) salvo(hwc int) (*Salvo, bool) {
+	for i := range r.Salvos {
+		if r.Salvos[i].Button == hwc {
+			return &r.Salvos[i], true
+		}
+	}
+	return nil, false
+}
+
+// hwcs returns the HWC IDs of all routers and pages by setting name.
+func (cfg *Config) hwcs() map[string]int {
+	hwcs := make(map[string]int)
+	for i, r := range cfg.routers() {
+		for name, hwc := range r.hwcs(cfg.prefix(i)) {
+			hwcs[name] = hwc
+		}
+	}
+	for page, hwc := range cfg.PageButtons {
+		hwcs[fmt.Sprintf("pageButtons[%d]", page)] = hwc
+	}
+	return hwcs
+}
+
+// labelHWCs returns the HWC IDs showing labels of all routers by setting
+// name.
+func (cfg *Config) labelHWCs() map[string]int {
+	hwcs := make(map[string]int)
+	for i, r := range cfg.routers() {
+		for name, hwc := range r.labelHWCs(cfg.prefix(i)) {
+			hwcs[name] = hwc
+		}
+	}
+	return hwcs
+}
+
+// hwcs returns the button HWC IDs of the router by setting name.
+func (r *RouterConfig) hwcs(prefix string) map[string]int {
+	hwcs := make(map[string]int)
+	for input, hwc := range r.SourceButtons {
+		hwcs[fmt.Sprintf("%ssourceButtons[%d]", prefix, input)] = hwc
+	}
+	for output, hwc := range r.DestinationButtons {
+		hwcs[fmt.Sprintf("%sdestinationButtons[%d]", prefix, output)] = hwc
+	}
+	if r.TakeButton != 0 {
+		hwcs[prefix+"takeButton"] = r.TakeButton
+	}
+	if r.LockButton != 0 {
+		hwcs[prefix+"lockButton"] = r.LockButton
+	}
+	if r.UndoButton != 0 {
+		hwcs[prefix+"undoButton"] = r.UndoButton
+	}
+	for i, salvo := range r.Salvos {
+		hwcs[fmt.Sprintf("%ssalvos[%d].button", prefix, i)] = salvo.Button
+	}
+	return hwcs
+}
+
+// labelHWCs returns the HWC IDs showing labels of the router by setting
+// name.
+func (r *RouterConfig) labelHWCs(prefix string) map[string]int {
+	hwcs := make(map[string]int)
+	for input, hwc := range r.SourceLabels {
+		hwcs[fmt.Sprintf("%ssourceLabels[%d]", prefix, input)] = hwc
+	}
+	for output, hwc := range r.DestinationLabels {
+		hwcs[fmt.Sprintf("%sdestinationLabels[%d]", prefix, output)] = hwc
+	}
+	return hwcs
+}
+
+// sourceLabel returns the HWC ID showing the label of a router input.
+func (r *RouterConfig) sourceLabel(input int) (int, bool) {
+	if len(r.SourceLabels) == 0 {
+		return at(r.SourceButtons, input)
+	}
+	return at(r.SourceLabels, input)
+}
+
+// destinationLabel returns the HWC ID showing the label of a router output.
+func (r *RouterConfig) destinationLabel(output int) (int, bool) {
+	if len(r.DestinationLabels) == 0 {
+		return at(r.DestinationButtons, output)
+	}
+	return at(r.DestinationLabels, output)
+}
+
+// sourceInput returns the router input selected by a button.
+func (r *RouterConfig) sourceInput(hwc int) (int, bool) {
+	return indexOf(r.SourceButtons, hwc)
+}
+
+// destinationOutput returns the router output selected by a button.
+func (r *RouterConfig) destinationOutput(hwc int) (int, bool) {
+	return indexOf(r.DestinationButtons, hwc)
+}
+
+// inSalvo reports whether any salvo of the router routes to output.
+func (r *RouterConfig) inSalvo(output int) bool {
+	for _, salvo := range r.Salvos {
+		for _, route := range salvo.Routes {
+			if route.Output == output {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// at returns hwcs[i] if i is in range.
+func at(hwcs []int, i int) (int, bool) {
+	if i < 0 || i >= len(hwcs) {
+		return 0, false
+	}
+	return hwcs[i], true
+}
+
+func indexOf(hwcs []int, hwc int) (int, bool) {
+	for i, id := range hwcs {
+		if id == hwc {
+			return i, true
+		}
+	}
+	return 0, false
+}
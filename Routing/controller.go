@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Routing/videohub"
+	"rawpanel"
+)
+
+// flashDuration is how long a button stays red after a failed command.
+const flashDuration = time.Second
+
+// labelCharWidth is the approximate width in pixels of a character of the
+// panel's text font, used to fit labels to the displays.
+const labelCharWidth = 6
+
+// Reconnect delays after losing a router.
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// controller ties a bank or page of panel buttons to one router. It keeps
+// the XY selection: the destination output the source buttons act on and,
+// with a Take button, the source waiting to be taken.
+type controller struct {
+	name       string // Router name for messages and the route history
+	cfg        *RouterConfig
+	labelChars int  // Config.LabelChars
+	prefix     bool // Prefix messages with the name, as there are several routers
+	panel      *rawpanel.Client
+	hist       *history
+	done       chan struct{}                   // Closed by stop
+	router     atomic.Pointer[videohub.Client] // nil while disconnected
+
+	mu       sync.Mutex
+	output   int                // Selected destination
+	pending  int                // Preselected source waiting for Take, -1 if none
+	topology *rawpanel.Topology // Last topology reported by the panel, for display sizes
+	prelude  bool               // The router's initial status dump has been handled
+	active   bool               // The page of this router is shown on the panel
+	routing  []int              // Crosspoints as last seen, to tell what changed
+	expect   map[int]expected   // Routes sent from this panel, by output
+}
+
+// expected is a route sent from the panel, so its change is recorded as
+// made by the panel rather than by another client.
+type expected struct {
+	input int
+	by    string
+}
+
+// newController returns a controller for the router rc of cfg, showing it
+// on panel and recording its routes in hist.
+func newController(name string, cfg *Config, rc *RouterConfig, panel *rawpanel.Client, hist *history) *controller {
+	return &controller{
+		name:       name,
+		cfg:        rc,
+		labelChars: cfg.LabelChars,
+		prefix:     len(cfg.Routers) > 0,
+		panel:      panel,
+		hist:       hist,
+		done:       make(chan struct{}),
+		output:     rc.Output,
+		pending:    -1,
+		active:     true,
+		expect:     make(map[int]expected),
+	}
+}
+
+// run keeps a connection to the router at addr, reconnecting with
+// exponential backoff, and handles its changes. It returns after stop.
+func (c *controller) run(addr string) {
+	backoff := minBackoff
+	for {
+		router, err := videohub.Dial(addr)
+		if err != nil {
+			c.logf("Failed to connect to Video Hub: %v\n", err)
+			if !c.sleep(backoff) {
+				return
+			}
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = minBackoff
+		c.logf("Video Hub connected\n")
+		c.router.Store(router)
+
+		if !c.handleChanges(router) {
+			c.router.Store(nil)
+			router.Close()
+			return
+		}
+
+		c.logf("Error reading from Video Hub: %v\n", router.Err())
+		c.router.Store(nil)
+		router.Close()
+		c.mu.Lock()
+		c.pending = -1
+		c.prelude = false
+		c.mu.Unlock()
+		c.redraw()
+		if !c.sleep(backoff) {
+			return
+		}
+	}
+}
+
+// handleChanges handles the changes of router until the connection is lost.
+// It returns false if the controller was stopped.
+func (c *controller) handleChanges(router *videohub.Client) bool {
+	for {
+		select {
+		case change, ok := <-router.Changes():
+			if !ok {
+				return true
+			}
+			c.handleChange(change)
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// sleep waits for d and returns false if the controller was stopped
+// meanwhile.
+func (c *controller) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// stop makes run close the router connection and return.
+func (c *controller) stop() {
+	close(c.done)
+}
+
+// state returns a snapshot of the router state, empty while disconnected.
+func (c *controller) state() videohub.State {
+	if router := c.router.Load(); router != nil {
+		return router.State()
+	}
+	return videohub.State{}
+}
+
+// command runs f on the router, or fails while disconnected.
+func (c *controller) command(f func(*videohub.Client) error) error {
+	router := c.router.Load()
+	if router == nil {
+		return videohub.ErrClosed
+	}
+	return f(router)
+}
+
+// logf prints a message, prefixed with the router name if there are
+// several.
+func (c *controller) logf(format string, args ...interface{}) {
+	if c.prefix {
+		format = c.name + ": " + format
+	}
+	fmt.Printf(format, args...)
+}
+
+// setActive shows or hides the page of this router. A shown page is drawn
+// right away.
+func (c *controller) setActive(active bool) {
+	c.mu.Lock()
+	c.active = active
+	c.mu.Unlock()
+	if active {
+		c.redraw()
+		c.relabel()
+	}
+}
+
+func (c *controller) isActive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// handleEvent reacts to a button press on the panel.
+func (c *controller) handleEvent(ev rawpanel.Event) {
+	if ev.Trigger != rawpanel.TriggerDown {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.active {
+		return
+	}
+
+	if output, ok := c.cfg.destinationOutput(ev.HWC); ok {
+		// Selecting another destination drops a pending take
+		c.output = output
+		c.pending = -1
+		c.logf("Selected Output %d\n", output+1)
+		c.refresh()
+		return
+	}
+
+	if input, ok := c.cfg.sourceInput(ev.HWC); ok {
+		if c.cfg.TakeButton == 0 {
+			c.route(c.output, input, ev.HWC, byPanel)
+			return
+		}
+		// Pressing the preselected source again cancels it
+		if c.pending == input {
+			c.pending = -1
+		} else {
+			c.pending = input
+		}
+		c.refresh()
+		return
+	}
+
+	if ev.HWC == c.cfg.TakeButton && c.pending >= 0 {
+		c.route(c.output, c.pending, ev.HWC, byPanel)
+		c.pending = -1
+		c.refresh()
+		return
+	}
+
+	if salvo, ok := c.cfg.salvo(ev.HWC); ok {
+		c.recall(salvo, ev.HWC)
+		return
+	}
+
+	if ev.HWC == c.cfg.UndoButton {
+		c.undoRoute(c.output, ev.HWC)
+		return
+	}
+
+	if ev.HWC == c.cfg.LockButton {
+		c.toggleLock(c.output, ev.HWC)
+	}
+}
+
+// handleChange updates the panel after the router state changed.
+func (c *controller) handleChange(change videohub.Change) {
+	if change.Err != nil {
+		c.logf("Error parsing Video Hub status: %v\n", change.Err)
+	}
+
+	state := c.state()
+	switch change.Header {
+	case videohub.VideohubDevice:
+		c.logf("Video Hub %s: %d inputs, %d outputs\n", state.Device.ModelName, state.Device.Inputs, state.Device.Outputs)
+	case videohub.InputLabels:
+		// The change may be older than the state, which may have fewer
+		// inputs and outputs by now
+		for _, input := range change.Indexes {
+			if input >= len(state.InputLabels) {
+				continue
+			}
+			if c.preludeDone() {
+				c.logf("Input %d renamed to %q\n", input+1, state.InputLabels[input])
+			}
+			c.showInputLabel(input, state.InputLabels[input])
+		}
+	case videohub.OutputLabels:
+		for _, output := range change.Indexes {
+			if output >= len(state.OutputLabels) {
+				continue
+			}
+			if c.preludeDone() {
+				c.logf("Output %d renamed to %q\n", output+1, state.OutputLabels[output])
+			}
+			c.showOutputLabel(output, state.OutputLabels[output])
+		}
+	case videohub.VideoOutputLocks:
+		for _, output := range change.Indexes {
+			c.logf("Output %d is %s\n", output+1, state.Lock(output))
+		}
+		c.redraw()
+	case videohub.EndPrelude:
+		c.mu.Lock()
+		c.prelude = true
+		c.mu.Unlock()
+	case videohub.VideoOutputRouting:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		redraw := false
+		for _, output := range change.Indexes {
+			c.track(&state, output)
+			if output == c.output {
+				input, _ := state.Source(output)
+				c.logf("Current Input for Output %d: %d\n", output+1, input+1)
+				redraw = true
+			}
+			// Salvo buttons follow every crosspoint they cover
+			redraw = redraw || c.cfg.inSalvo(output)
+		}
+		if redraw {
+			c.refresh()
+		}
+	}
+}
+
+// track records a crosspoint change in the route history. Routes in the
+// initial status dump are only taken note of. The caller must hold c.mu.
+func (c *controller) track(state *videohub.State, output int) {
+	input, ok := state.Source(output)
+	if !ok {
+		return
+	}
+	from := -1
+	if output < len(c.routing) {
+		from = c.routing[output]
+	}
+	for len(c.routing) <= output {
+		c.routing = append(c.routing, -1)
+	}
+	c.routing[output] = input
+	if !c.prelude || from == input {
+		return
+	}
+
+	by := byExternal
+	if e, ok := c.expect[output]; ok && e.input == input {
+		by = e.by
+		delete(c.expect, output)
+	}
+	c.hist.record(routeChange{
+		Time:   time.Now(),
+		Router: c.name,
+		Output: output,
+		From:   from,
+		To:     input,
+		By:     by,
+	})
+}
+
+// preludeDone reports whether the initial status dump has been handled, so
+// further label blocks are renames.
+func (c *controller) preludeDone() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prelude
+}
+
+// setTopology takes the display sizes from a new panel topology and shows
+// the labels again to fit them.
+func (c *controller) setTopology(topology *rawpanel.Topology) {
+	c.mu.Lock()
+	c.topology = topology
+	c.mu.Unlock()
+	c.relabel()
+}
+
+// relabel shows all labels of the router.
+func (c *controller) relabel() {
+	state := c.state()
+	for input, label := range state.InputLabels {
+		c.showInputLabel(input, label)
+	}
+	for output, label := range state.OutputLabels {
+		c.showOutputLabel(output, label)
+	}
+}
+
+// showInputLabel shows the label of a router input on its display, if it
+// has one.
+func (c *controller) showInputLabel(input int, label string) {
+	if !c.isActive() {
+		return
+	}
+	if hwc, ok := c.cfg.sourceLabel(input); ok {
+		c.setLabel(hwc, fmt.Sprintf("Input %d", input+1), c.fit(hwc, label))
+	}
+}
+
+// showOutputLabel shows the label of a router output on its display, if it
+// has one.
+func (c *controller) showOutputLabel(output int, label string) {
+	if !c.isActive() {
+		return
+	}
+	if hwc, ok := c.cfg.destinationLabel(output); ok {
+		c.setLabel(hwc, fmt.Sprintf("Output %d", output+1), c.fit(hwc, label))
+	}
+}
+
+// fit cuts a label to the length set by LabelChars, or to what fits on the
+// display of hwc. Labels for displays of unknown size are left alone.
+func (c *controller) fit(hwc int, label string) string {
+	chars := c.labelChars
+	if chars == 0 {
+		c.mu.Lock()
+		w, _, ok := c.topology.DisplaySize(hwc)
+		c.mu.Unlock()
+		if !ok {
+			return label
+		}
+		chars = w / labelCharWidth
+	}
+
+	if runes := []rune(label); len(runes) > chars {
+		return strings.TrimSpace(string(runes[:chars]))
+	}
+	return label
+}
+
+// route sends a crosspoint change to the router, unless another client
+// holds the lock on the output. The pressed button flashes red if the
+// route is refused or fails. by tells the route history who routed. The
+// caller must hold c.mu.
+func (c *controller) route(output, input, hwc int, by string) {
+	state := c.state()
+	if state.Lock(output) == videohub.Locked {
+		c.logf("Output %d is locked by another client, not routing Input %d\n", output+1, input+1)
+		c.flashError(hwc)
+		return
+	}
+
+	c.logf("Routing Input %d to Output %d\n", input+1, output+1)
+	c.expect[output] = expected{input: input, by: by}
+	c.do(hwc, func(router *videohub.Client) error {
+		err := router.Route(output, input)
+		if err != nil {
+			c.mu.Lock()
+			delete(c.expect, output)
+			c.mu.Unlock()
+		}
+		return err
+	})
+}
+
+// undoRoute routes the source an output had before its last change back.
+// The caller must hold c.mu.
+func (c *controller) undoRoute(output, hwc int) {
+	input, ok := c.hist.previous(c.name, output)
+	if !ok {
+		c.logf("Nothing to undo on Output %d\n", output+1)
+		c.flashError(hwc)
+		return
+	}
+	c.route(output, input, hwc, byUndo)
+}
+
+// recall switches all crosspoints of a salvo in one block, so the router
+// takes them at once. Nothing is routed if any of the outputs is locked by
+// another client. The caller must hold c.mu.
+func (c *controller) recall(salvo *Salvo, hwc int) {
+	state := c.state()
+	for _, r := range salvo.Routes {
+		if state.Lock(r.Output) == videohub.Locked {
+			c.logf("Output %d is locked by another client, not recalling salvo %s\n", r.Output+1, salvo.Name)
+			c.flashError(hwc)
+			return
+		}
+	}
+
+	c.logf("Recalling salvo %s\n", salvo.Name)
+	for _, r := range salvo.Routes {
+		c.expect[r.Output] = expected{input: r.Input, by: byPanel}
+	}
+	c.do(hwc, func(router *videohub.Client) error {
+		return router.Do(videohub.RouteBlock(salvo.routes()))
+	})
+}
+
+// do runs a router command in the background, so the panel stays responsive
+// while waiting for the reply, and flashes hwc red if it fails.
+func (c *controller) do(hwc int, f func(*videohub.Client) error) {
+	go func() {
+		err := c.command(f)
+		if err != nil {
+			c.logf("Video Hub command failed: %v\n", err)
+			c.mu.Lock()
+			c.flashError(hwc)
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// flashError lights hwc red for a moment, then restores the panel. The
+// caller must hold c.mu.
+func (c *controller) flashError(hwc int) {
+	if !c.active {
+		return
+	}
+	c.setButton(hwc, rawpanel.StateOn, rawpanel.ColorRed)
+	time.AfterFunc(flashDuration, func() {
+		select {
+		case <-c.done:
+		default:
+			c.redraw()
+		}
+	})
+}
+
+// toggleLock takes the lock on an output or releases our own. Locks held by
+// other clients are left alone. The caller must hold c.mu.
+func (c *controller) toggleLock(output, hwc int) {
+	state := c.state()
+	var lock videohub.Lock
+	switch state.Lock(output) {
+	case videohub.Owned:
+		lock = videohub.Unlocked
+	case videohub.Unlocked:
+		lock = videohub.Owned
+	default:
+		c.logf("Output %d is locked by another client\n", output+1)
+		c.flashError(hwc)
+		return
+	}
+
+	c.logf("Setting Output %d %s\n", output+1, lock)
+	c.do(hwc, func(router *videohub.Client) error {
+		return router.SetLock(output, lock)
+	})
+}
+
+// redraw redraws all button LEDs.
+func (c *controller) redraw() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh()
+}
+
+// refresh redraws all button LEDs if the page of the router is shown. The
+// caller must hold c.mu.
+func (c *controller) refresh() {
+	if !c.active {
+		return
+	}
+	state := c.state()
+	current, routed := state.Source(c.output)
+
+	// Tally: the current source of the selected destination is red, a
+	// preselected source waiting for Take is green
+	for input, hwc := range c.cfg.SourceButtons {
+		switch {
+		case input == c.pending:
+			c.setButton(hwc, rawpanel.StateOn, rawpanel.ColorGreen)
+		case routed && input == current:
+			c.setButton(hwc, rawpanel.StateOn, rawpanel.ColorRed)
+		default:
+			c.setButton(hwc, rawpanel.StateOff, rawpanel.ColorRed)
+		}
+	}
+
+	// Destinations: the selected one is lit in the color of its lock state,
+	// outputs locked by other clients are always lit red
+	for output, hwc := range c.cfg.DestinationButtons {
+		lock := state.Lock(output)
+		switch {
+		case output == c.output:
+			c.setButton(hwc, rawpanel.StateOn, lockColor(lock))
+		case lock == videohub.Locked:
+			c.setButton(hwc, rawpanel.StateOn, rawpanel.ColorRed)
+		default:
+			c.setButton(hwc, rawpanel.StateOff, rawpanel.ColorAmber)
+		}
+	}
+
+	// Salvos are lit while the router matches all of their routes
+	for _, salvo := range c.cfg.Salvos {
+		if salvoActive(&state, &salvo) {
+			c.setButton(salvo.Button, rawpanel.StateOn, rawpanel.ColorGreen)
+		} else {
+			c.setButton(salvo.Button, rawpanel.StateOff, rawpanel.ColorGreen)
+		}
+	}
+
+	if c.cfg.UndoButton != 0 {
+		if _, ok := c.hist.previous(c.name, c.output); ok {
+			c.setButton(c.cfg.UndoButton, rawpanel.StateOn, rawpanel.ColorAmber)
+		} else {
+			c.setButton(c.cfg.UndoButton, rawpanel.StateOff, rawpanel.ColorAmber)
+		}
+	}
+
+	if c.cfg.LockButton != 0 {
+		if lock := state.Lock(c.output); lock == videohub.Unlocked {
+			c.setButton(c.cfg.LockButton, rawpanel.StateOff, rawpanel.ColorRed)
+		} else {
+			c.setButton(c.cfg.LockButton, rawpanel.StateOn, lockColor(lock))
+		}
+	}
+
+	if c.cfg.TakeButton != 0 {
+		if c.pending >= 0 {
+			c.setButton(c.cfg.TakeButton, rawpanel.StateOn, rawpanel.ColorRed)
+		} else {
+			c.setButton(c.cfg.TakeButton, rawpanel.StateOff, rawpanel.ColorRed)
+		}
+	}
+}
+
+// setButton lights or clears the LED of a button on the panel.
+func (c *controller) setButton(hwc, mode, color int) {
+	setButton(c.panel, hwc, mode, color)
+}
+
+// setLabel shows a label on the display of hwc.
+func (c *controller) setLabel(hwc int, title, label string) {
+	setLabel(c.panel, hwc, title, label)
+}
+
+// salvoActive reports whether every route of salvo is in place.
+func salvoActive(state *videohub.State, salvo *Salvo) bool {
+	for _, r := range salvo.Routes {
+		if input, ok := state.Source(r.Output); !ok || input != r.Input {
+			return false
+		}
+	}
+	return true
+}
+
+// lockColor returns the LED color for an output lock state: red when locked
+// by another client, green when locked by us, amber when unlocked.
+func lockColor(lock videohub.Lock) int {
+	switch lock {
+	case videohub.Locked:
+		return rawpanel.ColorRed
+	case videohub.Owned:
+		return rawpanel.ColorGreen
+	}
+	return rawpanel.ColorAmber
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"Routing/videohub"
+	"Routing/videohub/videohubsim"
+	"rawpanel"
+	"rawpanel/panelsim"
+)
+
+const waitTimeout = 5 * time.Second
+
+// Buttons of the test panel.
+const (
+	takeButton  = 20
+	lockButton  = 21
+	undoButton  = 22
+	salvoButton = 23
+)
+
+var (
+	sourceButtons      = []int{1, 2, 3, 4, 5, 6, 7, 8}
+	destinationButtons = []int{11, 12, 13, 14, 15, 16, 17, 18}
+)
+
+// rig is a controller wired to a simulated panel and router, with the
+// panel events handled like in main.
+type rig struct {
+	t     *testing.T
+	panel *panelsim.Server
+	hub   *videohubsim.Server
+	c     *controller
+	mark  int // Lines received by the panel before the last press
+}
+
+func newRig(t *testing.T, rc RouterConfig) *rig {
+	t.Helper()
+	hub, err := videohubsim.New("127.0.0.1:0", videohubsim.Config{Inputs: 8, Outputs: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hub.Close() })
+	panel, err := panelsim.New("127.0.0.1:0", panelsim.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { panel.Close() })
+
+	rc.Videohub = hub.Addr()
+	config := &Config{Panel: panel.Addr(), RouterConfig: rc}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	history, err := openHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := rawpanel.Connect(config.Panel, rawpanel.Options{})
+	t.Cleanup(func() { conn.Close() })
+	c := newController("Video Hub 1", config, &config.RouterConfig, conn, history)
+	stopped := make(chan struct{})
+	go func() {
+		c.run(hub.Addr())
+		close(stopped)
+	}()
+	// Stop the controller before the router and panel go away
+	t.Cleanup(func() {
+		c.stop()
+		<-stopped
+	})
+	go func() {
+		for ev := range conn.Events() {
+			c.handleEvent(ev)
+		}
+	}()
+
+	r := &rig{t: t, panel: panel, hub: hub, c: c}
+	if err := panel.WaitConnected(waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+	r.waitFor("router connected", func() bool { return c.preludeDone() })
+	return r
+}
+
+// waitFor fails the test if cond does not become true in time.
+func (r *rig) waitFor(what string, cond func() bool) {
+	r.t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			r.t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// press presses a button. waitColor looks at what the panel received from
+// then on.
+func (r *rig) press(hwc int) {
+	r.t.Helper()
+	r.mark = len(r.panel.Received())
+	if err := r.panel.Press(hwc); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// waitRouted waits until the router has input on output.
+func (r *rig) waitRouted(output, input int) {
+	r.t.Helper()
+	r.waitFor("route", func() bool { return r.hub.Routing()[output] == input })
+}
+
+// waitColor waits until the LED of hwc was lit in color since the last
+// press. A flash may already be over by the time it is checked, so every
+// command received is looked at.
+func (r *rig) waitColor(hwc, color int) {
+	r.t.Helper()
+	err := r.panel.WaitFor(waitTimeout, func() bool {
+		for _, line := range r.panel.Received()[r.mark:] {
+			var s rawpanel.State
+			if json.Unmarshal([]byte(line), &s) != nil || len(s.HWCIDs) != 1 || s.HWCIDs[0] != hwc {
+				continue
+			}
+			if s.HWCMode != nil && s.HWCMode.State == rawpanel.StateOn &&
+				s.HWCColor != nil && s.HWCColor.ColorIndex != nil && s.HWCColor.ColorIndex.Index == color {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		r.t.Fatalf("HWC %d not lit in color %d: %v", hwc, color, err)
+	}
+}
+
+// lock returns the lock state of output as the controller sees it.
+func (r *rig) lock(output int) videohub.Lock {
+	state := r.c.state()
+	return state.Lock(output)
+}
+
+// assertUnrouted checks that output keeps input for a while.
+func (r *rig) assertUnrouted(output, input int) {
+	r.t.Helper()
+	time.Sleep(200 * time.Millisecond)
+	if got := r.hub.Routing()[output]; got != input {
+		r.t.Fatalf("output %d: got input %d, want %d unchanged", output, got, input)
+	}
+}
+
+func TestXYTake(t *testing.T) {
+	r := newRig(t, RouterConfig{
+		SourceButtons:      sourceButtons,
+		DestinationButtons: destinationButtons,
+		TakeButton:         takeButton,
+	})
+
+	r.press(destinationButtons[2])
+	r.press(sourceButtons[5])
+	r.assertUnrouted(2, 2)
+	r.press(takeButton)
+	r.waitRouted(2, 5)
+}
+
+func TestNAKFlashesRed(t *testing.T) {
+	r := newRig(t, RouterConfig{SourceButtons: sourceButtons})
+
+	r.hub.InjectNAK(1)
+	r.press(sourceButtons[3])
+	r.waitColor(sourceButtons[3], rawpanel.ColorRed)
+	r.assertUnrouted(0, 0)
+}
+
+func TestLockedOutputRefused(t *testing.T) {
+	r := newRig(t, RouterConfig{SourceButtons: sourceButtons, LockButton: lockButton})
+
+	r.hub.LockByOther(0, true)
+	r.waitFor("lock", func() bool { return r.lock(0) == videohub.Locked })
+	r.press(sourceButtons[3])
+	r.waitColor(sourceButtons[3], rawpanel.ColorRed)
+	r.press(lockButton)
+	r.waitColor(lockButton, rawpanel.ColorRed)
+	r.assertUnrouted(0, 0)
+
+	// The panel must still respond after refusing
+	r.hub.LockByOther(0, false)
+	r.waitFor("unlock", func() bool { return r.lock(0) == videohub.Unlocked })
+	r.press(sourceButtons[3])
+	r.waitRouted(0, 3)
+}
+
+func TestSalvo(t *testing.T) {
+	r := newRig(t, RouterConfig{
+		SourceButtons: sourceButtons,
+		Salvos: []Salvo{{
+			Name:   "Studio",
+			Button: salvoButton,
+			Routes: []Route{{Output: 1, Input: 6}, {Output: 4, Input: 7}},
+		}},
+	})
+
+	r.press(salvoButton)
+	r.waitRouted(1, 6)
+	r.waitRouted(4, 7)
+	r.waitColor(salvoButton, rawpanel.ColorGreen)
+
+	// A locked output stops the whole salvo
+	r.hub.Route(1, 0)
+	r.hub.LockByOther(4, true)
+	r.waitFor("lock", func() bool { return r.lock(4) == videohub.Locked })
+	r.press(salvoButton)
+	r.waitColor(salvoButton, rawpanel.ColorRed)
+	r.assertUnrouted(1, 0)
+}
+
+func TestUndo(t *testing.T) {
+	r := newRig(t, RouterConfig{SourceButtons: sourceButtons, UndoButton: undoButton})
+
+	r.press(undoButton)
+	r.waitColor(undoButton, rawpanel.ColorRed)
+
+	r.press(sourceButtons[3])
+	r.waitRouted(0, 3)
+	r.press(sourceButtons[6])
+	r.waitRouted(0, 6)
+
+	r.press(undoButton)
+	r.waitRouted(0, 3)
+	r.press(undoButton)
+	r.waitRouted(0, 0)
+}
+
+func TestStop(t *testing.T) {
+	hub, err := videohubsim.New("127.0.0.1:0", videohubsim.Config{Inputs: 8, Outputs: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	config := &Config{RouterConfig: RouterConfig{Videohub: hub.Addr()}}
+	history, err := openHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newController("Video Hub 1", config, &config.RouterConfig, rawpanel.Connect("127.0.0.1:0", rawpanel.Options{}), history)
+	defer c.panel.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.run(hub.Addr())
+		close(stopped)
+	}()
+	r := &rig{t: t, c: c}
+	r.waitFor("router connected", func() bool { return c.preludeDone() })
+
+	// Stopping while connected closes the connection
+	c.stop()
+	select {
+	case <-stopped:
+	case <-time.After(waitTimeout):
+		t.Fatal("run did not return after stop")
+	}
+	if c.router.Load() != nil {
+		t.Error("router connection kept after stop")
+	}
+
+	// Stopping while waiting to reconnect returns without redialing
+	c = newController("Video Hub 1", config, &config.RouterConfig, c.panel, history)
+	hub.Close()
+	stopped = make(chan struct{})
+	go func() {
+		c.run(hub.Addr())
+		close(stopped)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	c.stop()
+	select {
+	case <-stopped:
+	case <-time.After(minBackoff / 2):
+		t.Fatal("run kept waiting to reconnect after stop")
+	}
+}
+
+func TestStaleLabelChange(t *testing.T) {
+	r := newRig(t, RouterConfig{SourceButtons: sourceButtons})
+
+	// Label changes queued before the router shrank, or before it went away,
+	// name indexes the current state no longer has
+	r.c.handleChange(videohub.Change{Header: videohub.InputLabels, Indexes: []int{7, 40}})
+	r.c.handleChange(videohub.Change{Header: videohub.OutputLabels, Indexes: []int{7, 40}})
+	r.hub.Close()
+	r.waitFor("router disconnected", func() bool { return r.c.router.Load() == nil })
+	r.c.handleChange(videohub.Change{Header: videohub.InputLabels, Indexes: []int{0}})
+	r.c.handleChange(videohub.Change{Header: videohub.OutputLabels, Indexes: []int{0}})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// historyDepth is how many earlier sources are kept per output for Undo.
+const historyDepth = 100
+
+// Who made a crosspoint change.
+const (
+	byPanel    = "panel"    // A source, Take or salvo button on this panel
+	byUndo     = "undo"     // The Undo button on this panel
+	byExternal = "external" // Another client or the router's front panel
+)
+
+// routeChange is one entry of the route history.
+type routeChange struct {
+	Time   time.Time `json:"time"`
+	Router string    `json:"router"`
+	Output int       `json:"output"` // Zero based
+	From   int       `json:"from"`   // Previous input, -1 if unknown
+	To     int       `json:"to"`
+	By     string    `json:"by"`
+}
+
+// history records crosspoint changes of all routers and keeps the earlier
+// sources of every output for Undo. With a log file the history survives a
+// restart.
+type history struct {
+	mu   sync.Mutex
+	file *os.File                 // nil if the history is not persisted
+	undo map[string]map[int][]int // Earlier inputs by router and output, latest last
+}
+
+// openHistory loads the route log at path, if any, and opens it for
+// appending. An empty path keeps the history in memory only. An unparsable
+// last line, e.g. cut short by a crash, is dropped from the file with a
+// warning; anywhere else it is an error.
+func openHistory(path string) (*history, error) {
+	h := &history{undo: make(map[string]map[int][]int)}
+	if path == "" {
+		return h, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var badLine error // Only an error if another line follows
+	var offset, badOffset int64
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != nil {
+			f.Close()
+			return nil, badLine
+		}
+		var c routeChange
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			badLine = fmt.Errorf("%s:%d: %w", path, line, err)
+			badOffset = offset
+		} else {
+			h.apply(c)
+		}
+		offset += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if badLine != nil {
+		fmt.Println("Warning: dropping the last line of the route history:", badLine)
+		// Cut it off, so the next change does not end up behind it
+		if err := f.Truncate(badOffset); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	h.file = f
+	return h, nil
+}
+
+// record adds a change to the history and the log file.
+func (h *history) record(c routeChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.apply(c)
+
+	if h.file == nil {
+		return
+	}
+	data, err := json.Marshal(c)
+	if err == nil {
+		_, err = h.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		fmt.Println("Failed to write route history:", err)
+	}
+}
+
+// apply updates the Undo stacks. Undoing a change takes its source off the
+// stack instead of adding the undone one. The caller must hold h.mu.
+func (h *history) apply(c routeChange) {
+	outputs, ok := h.undo[c.Router]
+	if !ok {
+		outputs = make(map[int][]int)
+		h.undo[c.Router] = outputs
+	}
+	stack := outputs[c.Output]
+
+	switch {
+	case c.By == byUndo:
+		if n := len(stack); n > 0 && stack[n-1] == c.To {
+			stack = stack[:n-1]
+		}
+	case c.From >= 0:
+		stack = append(stack, c.From)
+		if len(stack) > historyDepth {
+			stack = stack[len(stack)-historyDepth:]
+		}
+	}
+	outputs[c.Output] = stack
+}
+
+// previous returns the source an output had before its last change.
+func (h *history) previous(router string, output int) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stack := h.undo[router][output]
+	if len(stack) == 0 {
+		return 0, false
+	}
+	return stack[len(stack)-1], true
+}
+
+// Close closes the log file.
+func (h *history) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
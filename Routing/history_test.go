@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const historyLine = `{"time":"2026-01-02T10:00:00Z","router":"Video Hub 1","output":0,"from":2,"to":5,"by":"panel"}` + "\n"
+
+func writeHistory(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHistoryDropsTruncatedLastLine(t *testing.T) {
+	path := writeHistory(t, historyLine+`{"time":"2026-01-02T10:00:01Z","rou`)
+	h, err := openHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if input, ok := h.previous("Video Hub 1", 0); !ok || input != 2 {
+		t.Errorf("got previous input %d, %v; want 2 from the valid line", input, ok)
+	}
+
+	// Changes recorded after the skipped line must load on the next start
+	h.record(routeChange{Router: "Video Hub 1", Output: 1, From: 3, To: 4, By: byPanel})
+	h.Close()
+	h, err = openHistory(path)
+	if err != nil {
+		t.Fatalf("reopening after recording: %v", err)
+	}
+	defer h.Close()
+	if input, ok := h.previous("Video Hub 1", 1); !ok || input != 3 {
+		t.Errorf("got previous input %d, %v; want 3 from the recorded change", input, ok)
+	}
+}
+
+func TestHistoryRejectsBadLine(t *testing.T) {
+	path := writeHistory(t, historyLine+"garbage\n"+historyLine)
+	if h, err := openHistory(path); err == nil {
+		h.Close()
+		t.Error("got no error for an unparsable line before the last one")
+	}
+}
@@ -1,196 +1,164 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"net"
-	"strconv"
-	"strings"
-	"sync"
+	"os"
+
+	"Routing/videohub/videohubsim"
+	"rawpanel"
 )
 
-var mu sync.Mutex
-var currentInputForOutput3 int
-var receivingVideoRoutingInfo bool
-var rawPanelConn net.Conn
-var inputLabels [16]string // Assuming 16 inputs, adjust as needed
+var rawPanelConn *rawpanel.Client
+var cfg *Config
+var hist *history
 
 func main() {
-	// Connect to the Raw Panel Server
+	// Load the configuration from file, environment and flags
 	var err error
-	rawPanelConn, err = net.Dial("tcp", "192.168.11.5:9973")
+	cfg, err = loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Println("Failed to connect to Raw Panel Server:", err)
-		return
+		fmt.Println("Error loading configuration:", err)
+		os.Exit(2)
 	}
-	defer rawPanelConn.Close()
 
-	// Connect to the Video Hub
-	videoHubConn, err := net.Dial("tcp", "192.168.10.61:9990")
+	// Load the route history for Undo
+	hist, err = openHistory(cfg.HistoryFile)
 	if err != nil {
-		fmt.Println("Failed to connect to Video Hub:", err)
-		return
+		fmt.Println("Error loading route history:", err)
+		os.Exit(2)
 	}
-	defer videoHubConn.Close()
-
-	// Send initial command to Raw Panel Server
-	rawPanelConn.Write([]byte("list\n"))
-	rawPanelConn.Write([]byte("Clear\n"))
-
-	// Create a goroutine to continuously read and update the current input and labels
-	go func() {
-		scanner := bufio.NewScanner(videoHubConn)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.HasPrefix(line, "VIDEO OUTPUT ROUTING:") {
-				// Set the flag to indicate that we are receiving video routing info
-				receivingVideoRoutingInfo = true
-			} else if strings.HasPrefix(line, "INPUT LABELS:") {
-				// Parse input labels
-				for i := 0; i < 16; i++ { // Assuming 16 inputs, adjust as needed
-					if scanner.Scan() {
-						label := scanner.Text()
-						inputLabels[i] = label
-						updateInputLabel(i, label)
-					}
-				}
-			} else if receivingVideoRoutingInfo {
-				// Find the line starting with "2" and extract the input number
-				parts := strings.Fields(line)
-				if len(parts) == 2 && parts[0] == "2" {
-					inputNumber := parts[1]
-					// Convert the input number to an integer
-					input, err := strconv.Atoi(inputNumber)
-					if err != nil {
-						fmt.Println("Error converting input number:", err)
-						continue
-					}
-
-					// Update the global variable with the current input for output 3
-					mu.Lock()
-					previousInput := currentInputForOutput3
-					currentInputForOutput3 = input
-					mu.Unlock()
-
-					// Output the current input to the console
-					fmt.Printf("Current Input for Output 3: %d\n", input)
-
-					// Turn off the previous input
-					//if previousInput != 0 {
-					turnOffInput(previousInput + 1)
-					//}
-
-					// Turn on the new input
-					turnOnInput(input + 1)
-				} else {
-					// Reset the flag when encountering another header
-					receivingVideoRoutingInfo = false
-				}
+	defer hist.Close()
+
+	// Connect to the Raw Panel Server, reconnecting whenever it goes away.
+	// Tally and labels are replayed by the client after a reconnect. New
+	// topologies are handed to the main loop, which refits the labels.
+	topologies := make(chan *rawpanel.Topology, 1)
+	rawPanelConn = rawpanel.Connect(cfg.Panel, rawpanel.Options{
+		InitCommands: []string{"Clear"},
+		OnStateChange: func(state rawpanel.ConnState) {
+			fmt.Println("Raw Panel Server", state)
+		},
+		OnTopology: func(topology *rawpanel.Topology) {
+			if err := topology.CheckHWCs(cfg.hwcs()); err != nil {
+				fmt.Println("Warning:", err)
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			fmt.Println("Error reading from Video Hub:", err)
-		}
-	}()
-
-	// Create a scanner to read from the Raw Panel Server
-	scanner := bufio.NewScanner(rawPanelConn)
-	for scanner.Scan() {
-		command := scanner.Text()
-		if strings.HasSuffix(command, "=Down") {
-			// Extract the button number from the command
-			parts := strings.Split(command, "=")
-			buttonNumber := parts[0][4:]
-
-			// Convert the button number to input number (1-8 to 0-7)
-			inputNumber := fmt.Sprintf("%d", (int(buttonNumber[0]) - '0' - 1))
-
-			fmt.Println(inputNumber)
-
-			// Prepare the command to send to the Video Hub
-			videoHubCommand := fmt.Sprintf("VIDEO OUTPUT ROUTING:\n2 %s\n\n", inputNumber)
+			if err := topology.CheckHWCs(cfg.labelHWCs()); err != nil {
+				fmt.Println("Warning:", err)
+			}
+			// Replace a topology the main loop has not taken yet
+			select {
+			case <-topologies:
+			default:
+			}
+			topologies <- topology
+		},
+		OnError: func(err error) {
+			fmt.Println("Skipping malformed event:", err)
+		},
+	})
+	defer rawPanelConn.Close()
 
-			// Send the command to the Video Hub
-			_, err := videoHubConn.Write([]byte(videoHubCommand))
+	// Start a controller with its own connection and reconnect loop for
+	// every Video Hub
+	var controllers []*controller
+	var addrs []string
+	for i, rc := range cfg.routers() {
+		c := newController(cfg.routerName(i), cfg, rc, rawPanelConn, hist)
+		controllers = append(controllers, c)
+
+		addr := rc.Videohub
+		if cfg.Simulate {
+			sim, err := videohubsim.New("127.0.0.1:0", videohubsim.Config{})
 			if err != nil {
-				fmt.Println("Failed to send command to Video Hub:", err)
+				fmt.Println("Failed to start simulated Video Hub:", err)
+				return
 			}
+			defer sim.Close()
+			addr = sim.Addr()
+			fmt.Printf("Simulated %s listening on %s\n", cfg.routerName(i), addr)
 		}
+		addrs = append(addrs, addr)
 	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading from Raw Panel Server:", err)
+	page := 0
+	showPage(controllers, page)
+	for i, c := range controllers {
+		go c.run(addrs[i])
 	}
-}
 
-func turnOnInput(inputNumber int) {
-	inputCommand := map[string]interface{}{
-		"HWCIDs":      []int{inputNumber},
-		"HWCMode":     map[string]interface{}{"State": 4},
-		"HWCColor":    map[string]interface{}{"ColorIndex": map[string]interface{}{"Index": 4}},
-		"HWCExtended": map[string]interface{}{},
-		"HWCText":     map[string]interface{}{"Formatting": 7},
+	// Read HWC events from the Raw Panel Server
+	for {
+		select {
+		case ev, ok := <-rawPanelConn.Events():
+			if !ok {
+				return
+			}
+			if p, ok := indexOf(cfg.PageButtons, ev.HWC); ok && ev.Trigger == rawpanel.TriggerDown {
+				if p != page {
+					page = p
+					rawPanelConn.SendLine("Clear")
+					showPage(controllers, page)
+				}
+				continue
+			}
+			for _, c := range controllers {
+				c.handleEvent(ev)
+			}
+		case topology := <-topologies:
+			for _, c := range controllers {
+				c.setTopology(topology)
+			}
+		}
 	}
+}
 
-	commandJSON, err := json.Marshal(inputCommand)
-	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
+// showPage makes the panel control the router of a page. Without pages all
+// routers are shown, each on its own bank of buttons.
+func showPage(controllers []*controller, page int) {
+	if len(cfg.PageButtons) == 0 {
+		for _, c := range controllers {
+			c.setActive(true)
+		}
 		return
 	}
 
-	// Send the JSON command to the Raw Panel Server
-	_, err = rawPanelConn.Write(commandJSON)
-	_, err = rawPanelConn.Write([]byte("\n"))
-	if err != nil {
-		fmt.Println("Failed to send command to Raw Panel Server:", err)
+	fmt.Println("Showing", cfg.routerName(page))
+	for i, c := range controllers {
+		if i != page {
+			c.setActive(false)
+		}
 	}
-}
-
-func turnOffInput(inputNumber int) {
-	inputCommand := map[string]interface{}{
-		"HWCIDs":      []int{inputNumber},
-		"HWCMode":     map[string]interface{}{"State": 0},
-		"HWCColor":    map[string]interface{}{"ColorIndex": map[string]interface{}{"Index": 4}},
-		"HWCExtended": map[string]interface{}{},
-		"HWCText":     map[string]interface{}{"Formatting": 7},
+	controllers[page].setActive(true)
+	for p, hwc := range cfg.PageButtons {
+		if p == page {
+			setButton(rawPanelConn, hwc, rawpanel.StateOn, rawpanel.ColorAmber)
+		} else {
+			setButton(rawPanelConn, hwc, rawpanel.StateOff, rawpanel.ColorAmber)
+		}
 	}
+}
 
-	commandJSON, err := json.Marshal(inputCommand)
-	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
-		return
-	}
+func setButton(conn *rawpanel.Client, hwc, mode, color int) {
+	buttonCommand := rawpanel.NewState(hwc).
+		Mode(mode).
+		ColorIndex(color)
 
 	// Send the JSON command to the Raw Panel Server
-	_, err = rawPanelConn.Write(commandJSON)
-	_, err = rawPanelConn.Write([]byte("\n"))
-	if err != nil {
+	err := conn.Send(buttonCommand)
+	// While disconnected the state is kept and sent on reconnect
+	if err != nil && err != rawpanel.ErrNotConnected {
 		fmt.Println("Failed to send command to Raw Panel Server:", err)
 	}
 }
 
-func updateInputLabel(inputNumber int, label string) {
-	// Construct the JSON command to update the input label
-	inputCommand := map[string]interface{}{
-		"HWCIDs": []int{inputNumber},
-		"HWCText": map[string]interface{}{
-			"Formatting": 7,
-			"Textline1":  "INPUT " + label,
-		},
-	}
-
-	commandJSON, err := json.Marshal(inputCommand)
-	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
-		return
-	}
+func setLabel(conn *rawpanel.Client, hwc int, title, label string) {
+	// Construct the JSON command to show the router label
+	labelCommand := rawpanel.NewState(hwc).
+		Text(rawpanel.HWCText{Formatting: 7, Title: title, Textline1: label})
 
 	// Send the JSON command to the Raw Panel Server
-	_, err = rawPanelConn.Write(commandJSON)
-	_, err = rawPanelConn.Write([]byte("\n"))
+	err := conn.Send(labelCommand)
 
-	if err != nil {
+	if err != nil && err != rawpanel.ErrNotConnected {
 		fmt.Println("Failed to send command to Raw Panel Server:", err)
 	}
 }
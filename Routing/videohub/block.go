@@ -0,0 +1,82 @@
+// Package videohub implements the Blackmagic Videohub Ethernet protocol.
+//
+// The router talks in blocks: a header line such as "VIDEO OUTPUT ROUTING:"
+// followed by zero or more body lines and terminated by an empty line. It
+// answers every block sent to it with "ACK" or "NAK" and broadcasts status
+// blocks to all clients whenever something changes.
+package videohub
+
+import (
+	"bufio"
+	"strings"
+)
+
+// Block headers, without the trailing colon.
+const (
+	ProtocolPreamble   = "PROTOCOL PREAMBLE"
+	VideohubDevice     = "VIDEOHUB DEVICE"
+	InputLabels        = "INPUT LABELS"
+	OutputLabels       = "OUTPUT LABELS"
+	VideoOutputLocks   = "VIDEO OUTPUT LOCKS"
+	VideoOutputRouting = "VIDEO OUTPUT ROUTING"
+	EndPrelude         = "END PRELUDE"
+	Ping               = "PING"
+	ACK                = "ACK"
+	NAK                = "NAK"
+)
+
+// Block is a single protocol block.
+type Block struct {
+	Header string   // Header without the trailing colon, e.g. "INPUT LABELS"
+	Lines  []string // Body lines
+}
+
+// String encodes the block for sending, including the terminating empty line.
+func (b Block) String() string {
+	var sb strings.Builder
+	sb.WriteString(b.Header)
+	if b.Header != ACK && b.Header != NAK {
+		sb.WriteByte(':')
+	}
+	sb.WriteByte('\n')
+	for _, line := range b.Lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+// ReadBlock reads the next block from r. Leading empty lines are skipped.
+func ReadBlock(r *bufio.Reader) (Block, error) {
+	var b Block
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return Block{}, err
+		}
+		if line != "" {
+			b.Header = strings.TrimSuffix(line, ":")
+			break
+		}
+	}
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return Block{}, err
+		}
+		if line == "" {
+			return b, nil
+		}
+		b.Lines = append(b.Lines, line)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,244 @@
+package videohub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned when sending on a client that has been closed.
+	ErrClosed = errors.New("videohub: client closed")
+
+	// ErrNAK is returned by Do when the router rejected a command.
+	ErrNAK = errors.New("videohub: command rejected (NAK)")
+
+	// ErrTimeout is returned by Do when the router did not answer in time.
+	ErrTimeout = errors.New("videohub: no reply from router")
+)
+
+// Client is a connection to a Videohub router.
+type Client struct {
+	// Timeout is how long Do waits for ACK or NAK, Retries how often it
+	// resends a command that timed out. Set them before first use.
+	Timeout time.Duration
+	Retries int
+
+	conn    net.Conn
+	changes chan Change
+
+	writeMu sync.Mutex // serializes writes and guards closed and pending
+	closed  bool
+	pending []chan error // Waiting for ACK or NAK, in the order sent
+
+	mu    sync.Mutex // guards state and err
+	state State
+	err   error
+}
+
+// Dial connects to the router at addr, e.g. "192.168.10.61:9990".
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient wraps an existing connection and starts reading from it.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		Timeout: 2 * time.Second,
+		Retries: 2,
+		conn:    conn,
+		changes: make(chan Change, 64),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Changes returns a channel that receives every block applied to the state,
+// as well as ACK and NAK replies (which Do has already matched to their
+// commands). It is closed when the connection is lost; Err reports why.
+func (c *Client) Changes() <-chan Change {
+	return c.changes
+}
+
+// State returns a snapshot of the router state.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Clone()
+}
+
+// Err returns the error that ended the read loop, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Send writes a block to the router without waiting for the reply.
+func (c *Client) Send(b Block) error {
+	_, err := c.send(b)
+	return err
+}
+
+// Do writes a block to the router and waits for its ACK. The router answers
+// commands in order, so replies are matched to commands first in, first
+// out. A command that gets no reply within Timeout is sent again up to
+// Retries times; a NAK is returned as ErrNAK right away.
+func (c *Client) Do(b Block) error {
+	for attempt := 0; ; attempt++ {
+		reply, err := c.send(b)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case err := <-reply:
+			return err
+		case <-time.After(c.Timeout):
+			if attempt >= c.Retries {
+				return ErrTimeout
+			}
+		}
+	}
+}
+
+// send writes b and queues a channel for its reply.
+func (c *Client) send(b Block) (chan error, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+
+	// Buffered so a late reply to a command that timed out never blocks
+	reply := make(chan error, 1)
+	c.pending = append(c.pending, reply)
+	return reply, nil
+}
+
+// reply completes the oldest pending command.
+func (c *Client) reply(err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if len(c.pending) == 0 {
+		return
+	}
+	c.pending[0] <- err
+	c.pending = c.pending[1:]
+}
+
+// failPending completes all pending commands with err once the connection
+// is gone, and makes further sends fail.
+func (c *Client) failPending(err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.closed = true
+	for _, reply := range c.pending {
+		reply <- err
+	}
+	c.pending = nil
+}
+
+// Route routes input to output and waits for the router to accept it.
+func (c *Client) Route(output, input int) error {
+	return c.Do(RouteBlock(map[int]int{output: input}))
+}
+
+// SetLock takes (Owned), releases (Unlocked) or breaks (Force) the lock on
+// an output.
+func (c *Client) SetLock(output int, lock Lock) error {
+	return c.Do(Block{
+		Header: VideoOutputLocks,
+		Lines:  []string{fmt.Sprintf("%d %c", output, lock)},
+	})
+}
+
+// SetInputLabel renames an input.
+func (c *Client) SetInputLabel(input int, label string) error {
+	return c.Do(Block{
+		Header: InputLabels,
+		Lines:  []string{fmt.Sprintf("%d %s", input, label)},
+	})
+}
+
+// SetOutputLabel renames an output.
+func (c *Client) SetOutputLabel(output int, label string) error {
+	return c.Do(Block{
+		Header: OutputLabels,
+		Lines:  []string{fmt.Sprintf("%d %s", output, label)},
+	})
+}
+
+// Ping asks the router for an ACK.
+func (c *Client) Ping() error {
+	return c.Do(Block{Header: Ping})
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	c.writeMu.Lock()
+	c.closed = true
+	c.writeMu.Unlock()
+	return c.conn.Close()
+}
+
+// RouteBlock builds a VIDEO OUTPUT ROUTING block from output to input pairs,
+// sorted by output.
+func RouteBlock(routes map[int]int) Block {
+	b := Block{Header: VideoOutputRouting}
+	for _, output := range sortedKeys(routes) {
+		b.Lines = append(b.Lines, fmt.Sprintf("%d %d", output, routes[output]))
+	}
+	return b
+}
+
+func sortedKeys(m map[int]int) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func (c *Client) readLoop() {
+	defer close(c.changes)
+
+	r := bufio.NewReader(c.conn)
+	for {
+		b, err := ReadBlock(r)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			c.failPending(ErrClosed)
+			return
+		}
+
+		switch b.Header {
+		case ACK:
+			c.reply(nil)
+		case NAK:
+			c.reply(ErrNAK)
+		}
+
+		// Malformed lines are reported in the change; the valid part of
+		// the block has been applied regardless
+		c.mu.Lock()
+		change, err := c.state.Apply(b)
+		c.mu.Unlock()
+		change.Err = err
+		c.changes <- change
+	}
+}
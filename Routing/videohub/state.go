@@ -0,0 +1,242 @@
+package videohub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Lock is the lock state of a router output as seen by this client.
+type Lock byte
+
+const (
+	Unlocked Lock = 'U' // Nobody holds the lock
+	Owned    Lock = 'O' // Locked by this client
+	Locked   Lock = 'L' // Locked by another client
+	Force    Lock = 'F' // Only sent: force unlock a lock held by another client
+)
+
+func (l Lock) String() string {
+	switch l {
+	case Unlocked:
+		return "unlocked"
+	case Owned:
+		return "owned"
+	case Locked:
+		return "locked"
+	case Force:
+		return "force"
+	}
+	return fmt.Sprintf("Lock(%q)", byte(l))
+}
+
+// Device is the content of the VIDEOHUB DEVICE block.
+type Device struct {
+	Present      string // "true", "false" or "needs_update"
+	ModelName    string
+	FriendlyName string
+	UniqueID     string
+	Inputs       int
+	Outputs      int
+}
+
+// State is the router state as learned from the blocks it sends.
+type State struct {
+	ProtocolVersion string
+	Device          Device
+
+	InputLabels  []string // Indexed by input
+	OutputLabels []string // Indexed by output
+	Routing      []int    // Input routed to each output
+	Locks        []Lock   // Lock state of each output
+
+	// PreludeDone is set once the initial status dump has been received.
+	PreludeDone bool
+}
+
+// Clone returns a deep copy of s.
+func (s *State) Clone() State {
+	c := *s
+	c.InputLabels = append([]string(nil), s.InputLabels...)
+	c.OutputLabels = append([]string(nil), s.OutputLabels...)
+	c.Routing = append([]int(nil), s.Routing...)
+	c.Locks = append([]Lock(nil), s.Locks...)
+	return c
+}
+
+// Lock returns the lock state of an output, Unlocked if unknown.
+func (s *State) Lock(output int) Lock {
+	if output < 0 || output >= len(s.Locks) {
+		return Unlocked
+	}
+	return s.Locks[output]
+}
+
+// Source returns the input routed to an output.
+func (s *State) Source(output int) (int, bool) {
+	if output < 0 || output >= len(s.Routing) || s.Routing[output] < 0 {
+		return 0, false
+	}
+	return s.Routing[output], true
+}
+
+// Change describes which entries a block updated.
+type Change struct {
+	Header  string // Header of the block that caused the change
+	Indexes []int  // Updated input or output indexes, if the block has any
+	Err     error  // Set if some lines of the block were malformed
+}
+
+// Apply updates s with a block received from the router. Unknown blocks are
+// ignored; malformed lines return an error after the valid ones have been
+// applied.
+func (s *State) Apply(b Block) (Change, error) {
+	change := Change{Header: b.Header}
+	var err error
+
+	switch b.Header {
+	case ProtocolPreamble:
+		for _, line := range b.Lines {
+			if key, value, ok := cutField(line); ok && key == "Version" {
+				s.ProtocolVersion = value
+			}
+		}
+	case VideohubDevice:
+		err = s.applyDevice(b.Lines)
+	case InputLabels:
+		change.Indexes, err = applyIndexed(b.Lines, s.Device.Inputs, func(i int, value string) error {
+			s.InputLabels = grow(s.InputLabels, i+1, "")
+			s.InputLabels[i] = value
+			return nil
+		})
+	case OutputLabels:
+		change.Indexes, err = applyIndexed(b.Lines, s.Device.Outputs, func(i int, value string) error {
+			s.OutputLabels = grow(s.OutputLabels, i+1, "")
+			s.OutputLabels[i] = value
+			return nil
+		})
+	case VideoOutputRouting:
+		change.Indexes, err = applyIndexed(b.Lines, s.Device.Outputs, func(i int, value string) error {
+			input, err := strconv.Atoi(value)
+			if err != nil || input < 0 || input >= limit(s.Device.Inputs) {
+				return fmt.Errorf("invalid input %q", value)
+			}
+			s.Routing = grow(s.Routing, i+1, -1)
+			s.Routing[i] = input
+			return nil
+		})
+	case VideoOutputLocks:
+		change.Indexes, err = applyIndexed(b.Lines, s.Device.Outputs, func(i int, value string) error {
+			if len(value) != 1 || !strings.Contains("UOL", value) {
+				return fmt.Errorf("invalid lock state %q", value)
+			}
+			s.Locks = grow(s.Locks, i+1, Unlocked)
+			s.Locks[i] = Lock(value[0])
+			return nil
+		})
+	case EndPrelude:
+		s.PreludeDone = true
+	}
+
+	if err != nil {
+		return change, fmt.Errorf("videohub: %s: %w", b.Header, err)
+	}
+	return change, nil
+}
+
+func (s *State) applyDevice(lines []string) error {
+	var errs []error
+	for _, line := range lines {
+		key, value, ok := cutField(line)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid line %q", line))
+			continue
+		}
+
+		switch key {
+		case "Device present":
+			s.Device.Present = value
+		case "Model name":
+			s.Device.ModelName = value
+		case "Friendly name":
+			s.Device.FriendlyName = value
+		case "Unique ID":
+			s.Device.UniqueID = value
+		case "Video inputs", "Video outputs":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxPorts {
+				errs = append(errs, fmt.Errorf("invalid count in %q", line))
+				continue
+			}
+			if key == "Video inputs" {
+				s.Device.Inputs = n
+				s.InputLabels = resize(s.InputLabels, n, "")
+			} else {
+				s.Device.Outputs = n
+				s.OutputLabels = resize(s.OutputLabels, n, "")
+				s.Routing = resize(s.Routing, n, -1)
+				s.Locks = resize(s.Locks, n, Unlocked)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cutField splits "Key: value" lines.
+func cutField(line string) (key, value string, ok bool) {
+	key, value, ok = strings.Cut(line, ":")
+	return key, strings.TrimSpace(value), ok
+}
+
+// applyIndexed calls set for every "<index> <value>" line with an index
+// below count and returns the indexes that were set. Malformed lines are
+// skipped and returned as one error.
+func applyIndexed(lines []string, count int, set func(index int, value string) error) ([]int, error) {
+	var indexes []int
+	var errs []error
+	for _, line := range lines {
+		idx, value, _ := strings.Cut(line, " ")
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 0 || i >= limit(count) {
+			errs = append(errs, fmt.Errorf("invalid index in %q", line))
+			continue
+		}
+		if err := set(i, value); err != nil {
+			errs = append(errs, fmt.Errorf("%w in %q", err, line))
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, errors.Join(errs...)
+}
+
+// maxPorts bounds the inputs and outputs a router may report, so a broken
+// router cannot make the state grow without limit. The largest Videohubs
+// have 288.
+const maxPorts = 1024
+
+// limit returns the number of ports indexes must stay below: count once
+// the device block has told it, else maxPorts.
+func limit(count int) int {
+	if count > 0 {
+		return count
+	}
+	return maxPorts
+}
+
+// grow extends s to at least n entries, filling with zero.
+func grow[T any](s []T, n int, zero T) []T {
+	for len(s) < n {
+		s = append(s, zero)
+	}
+	return s
+}
+
+// resize sets the length of s to exactly n entries, filling with zero.
+func resize[T any](s []T, n int, zero T) []T {
+	if len(s) > n {
+		return s[:n]
+	}
+	return grow(s, n, zero)
+}
@@ -0,0 +1,54 @@
+package videohub
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyBoundsIndexes(t *testing.T) {
+	var s State
+	if _, err := s.Apply(Block{Header: VideohubDevice, Lines: []string{
+		"Video inputs: 4",
+		"Video outputs: 2",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := s.Apply(Block{Header: VideoOutputRouting, Lines: []string{
+		"0 3",
+		"2 1",          // Output out of range
+		"1 4",          // Input out of range
+		"1000000000 0", // Would grow the state without limit
+		"x 0",
+		"1 2",
+	}})
+	if err == nil {
+		t.Error("got no error for the malformed lines")
+	}
+	if !slices.Equal(change.Indexes, []int{0, 1}) {
+		t.Errorf("got indexes %v, want [0 1]", change.Indexes)
+	}
+	if !slices.Equal(s.Routing, []int{3, 2}) {
+		t.Errorf("got routing %v, want [3 2]", s.Routing)
+	}
+
+	change, err = s.Apply(Block{Header: InputLabels, Lines: []string{"3 Cam 4", "4 Cam 5"}})
+	if err == nil || !slices.Equal(change.Indexes, []int{3}) || len(s.InputLabels) != 4 {
+		t.Errorf("got indexes %v, labels %q, error %v; want only input 3 set and an error", change.Indexes, s.InputLabels, err)
+	}
+}
+
+func TestApplyBoundsWithoutDevice(t *testing.T) {
+	var s State
+	change, err := s.Apply(Block{Header: OutputLabels, Lines: []string{"0 PGM", "5000 Too far"}})
+	if err == nil || !slices.Equal(change.Indexes, []int{0}) || len(s.OutputLabels) != 1 {
+		t.Errorf("got indexes %v, labels %q, error %v; want only output 0 set and an error", change.Indexes, s.OutputLabels, err)
+	}
+
+	if _, err := s.Apply(Block{Header: VideohubDevice, Lines: []string{"Video outputs: 1000000000"}}); err == nil {
+		t.Error("got no error for a huge output count")
+	}
+	if s.Device.Outputs != 0 || len(s.Locks) != 0 {
+		t.Errorf("huge output count applied: %d outputs, %d locks", s.Device.Outputs, len(s.Locks))
+	}
+}
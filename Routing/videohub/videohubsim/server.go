@@ -0,0 +1,491 @@
+// Package videohubsim provides a fake Videohub router that speaks the
+// Ethernet protocol, for running Routing and its tests without hardware.
+package videohubsim
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Routing/videohub"
+)
+
+// Config sets up the simulated router.
+type Config struct {
+	Model   string // Defaults to "Blackmagic Smart Videohub 16x16"
+	Inputs  int    // Defaults to 16
+	Outputs int    // Defaults to 16
+
+	// Labels default to "Input 1", "Output 1" and so on
+	InputLabels  []string
+	OutputLabels []string
+
+	// Routing sets the initial input of each output; default is input n to
+	// output n, wrapping around if there are fewer inputs.
+	Routing []int
+
+	// Latency delays every reply.
+	Latency time.Duration
+}
+
+// Server is a simulated Videohub.
+type Server struct {
+	ln net.Listener
+
+	mu           sync.Mutex
+	model        string
+	inputLabels  []string
+	outputLabels []string
+	routing      []int
+	locks        []*session // Lock owner per output, nil if unlocked
+	sessions     map[*session]bool
+	latency      time.Duration
+	naks         int // Commands left to reject
+	received     []videohub.Block
+	closed       bool
+
+	// other owns locks taken through LockByOther
+	other *session
+}
+
+type session struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+func (s *session) write(blocks ...videohub.Block) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for _, b := range blocks {
+		s.conn.Write([]byte(b.String()))
+	}
+}
+
+// New starts a simulated router listening on addr, e.g. "127.0.0.1:0" for
+// a random port.
+func New(addr string, cfg Config) (*Server, error) {
+	if cfg.Model == "" {
+		cfg.Model = "Blackmagic Smart Videohub 16x16"
+	}
+	if cfg.Inputs <= 0 {
+		cfg.Inputs = 16
+	}
+	if cfg.Outputs <= 0 {
+		cfg.Outputs = 16
+	}
+
+	s := &Server{
+		model:        cfg.Model,
+		inputLabels:  make([]string, cfg.Inputs),
+		outputLabels: make([]string, cfg.Outputs),
+		routing:      make([]int, cfg.Outputs),
+		locks:        make([]*session, cfg.Outputs),
+		sessions:     make(map[*session]bool),
+		latency:      cfg.Latency,
+		other:        &session{},
+	}
+	for i := range s.inputLabels {
+		s.inputLabels[i] = fmt.Sprintf("Input %d", i+1)
+		if i < len(cfg.InputLabels) {
+			s.inputLabels[i] = cfg.InputLabels[i]
+		}
+	}
+	for i := range s.outputLabels {
+		s.outputLabels[i] = fmt.Sprintf("Output %d", i+1)
+		if i < len(cfg.OutputLabels) {
+			s.outputLabels[i] = cfg.OutputLabels[i]
+		}
+	}
+	for i := range s.routing {
+		s.routing[i] = i % cfg.Inputs
+		if i < len(cfg.Routing) && cfg.Routing[i] >= 0 && cfg.Routing[i] < cfg.Inputs {
+			s.routing[i] = cfg.Routing[i]
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the router listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the router and drops all clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+	return s.ln.Close()
+}
+
+// SetLatency changes the delay before every reply.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// InjectNAK makes the router reject the next n commands.
+func (s *Server) InjectNAK(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.naks = n
+}
+
+// Received returns every block clients have sent, in order.
+func (s *Server) Received() []videohub.Block {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]videohub.Block(nil), s.received...)
+}
+
+// Routing returns the input routed to every output.
+func (s *Server) Routing() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.routing...)
+}
+
+// Route changes a crosspoint as if done from the front panel or another
+// client, and notifies all clients.
+func (s *Server) Route(output, input int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routing[output] = input
+	s.broadcast(func(*session) videohub.Block {
+		return indexed(videohub.VideoOutputRouting, map[int]string{output: strconv.Itoa(input)})
+	})
+}
+
+// SetInputLabel renames an input as if done by another client.
+func (s *Server) SetInputLabel(input int, label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputLabels[input] = label
+	s.broadcast(func(*session) videohub.Block {
+		return indexed(videohub.InputLabels, map[int]string{input: label})
+	})
+}
+
+// SetOutputLabel renames an output as if done by another client.
+func (s *Server) SetOutputLabel(output int, label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outputLabels[output] = label
+	s.broadcast(func(*session) videohub.Block {
+		return indexed(videohub.OutputLabels, map[int]string{output: label})
+	})
+}
+
+// LockByOther locks an output on behalf of another client, or unlocks it.
+func (s *Server) LockByOther(output int, locked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[output] = nil
+	if locked {
+		s.locks[output] = s.other
+	}
+	s.broadcastLocks(output)
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		sess := &session{conn: conn}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.sessions[sess] = true
+		sess.write(s.prelude(sess)...)
+		s.mu.Unlock()
+
+		go s.serve(sess)
+	}
+}
+
+func (s *Server) serve(sess *session) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		// Locks die with the connection that holds them
+		for output, owner := range s.locks {
+			if owner == sess {
+				s.locks[output] = nil
+				s.broadcastLocks(output)
+			}
+		}
+		s.mu.Unlock()
+		sess.conn.Close()
+	}()
+
+	r := bufio.NewReader(sess.conn)
+	for {
+		b, err := videohub.ReadBlock(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		latency := s.latency
+		s.mu.Unlock()
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, b)
+		s.handle(sess, b)
+		s.mu.Unlock()
+	}
+}
+
+// handle answers a block from a client. The caller must hold s.mu.
+func (s *Server) handle(sess *session, b videohub.Block) {
+	if s.naks > 0 {
+		s.naks--
+		sess.write(videohub.Block{Header: videohub.NAK})
+		return
+	}
+
+	// A known header without body asks for a status dump
+	if len(b.Lines) == 0 {
+		if dump, ok := s.dump(sess, b.Header); ok {
+			sess.write(videohub.Block{Header: videohub.ACK}, dump)
+			return
+		}
+	}
+
+	var after func()
+	var err error
+	switch b.Header {
+	case videohub.Ping:
+	case videohub.VideoOutputRouting:
+		after, err = s.applyRouting(sess, b.Lines)
+	case videohub.VideoOutputLocks:
+		after, err = s.applyLocks(sess, b.Lines)
+	case videohub.InputLabels:
+		after, err = s.applyLabels(s.inputLabels, videohub.InputLabels, b.Lines)
+	case videohub.OutputLabels:
+		after, err = s.applyLabels(s.outputLabels, videohub.OutputLabels, b.Lines)
+	default:
+		err = fmt.Errorf("unknown block %q", b.Header)
+	}
+
+	if err != nil {
+		sess.write(videohub.Block{Header: videohub.NAK})
+		return
+	}
+	sess.write(videohub.Block{Header: videohub.ACK})
+	if after != nil {
+		after()
+	}
+}
+
+// applyRouting validates all lines before changing anything, so a bad
+// block is rejected as a whole.
+func (s *Server) applyRouting(sess *session, lines []string) (func(), error) {
+	routes := make(map[int]string)
+	for _, line := range lines {
+		output, value, err := s.parseIndexed(line, len(s.routing))
+		if err != nil {
+			return nil, err
+		}
+		input, err := strconv.Atoi(value)
+		if err != nil || input < 0 || input >= len(s.inputLabels) {
+			return nil, fmt.Errorf("invalid input in %q", line)
+		}
+		if owner := s.locks[output]; owner != nil && owner != sess {
+			return nil, fmt.Errorf("output %d is locked", output)
+		}
+		routes[output] = value
+	}
+
+	return func() {
+		for output, value := range routes {
+			s.routing[output], _ = strconv.Atoi(value)
+		}
+		s.broadcast(func(*session) videohub.Block {
+			return indexed(videohub.VideoOutputRouting, routes)
+		})
+	}, nil
+}
+
+func (s *Server) applyLocks(sess *session, lines []string) (func(), error) {
+	owners := make(map[int]*session)
+	for _, line := range lines {
+		output, value, err := s.parseIndexed(line, len(s.locks))
+		if err != nil {
+			return nil, err
+		}
+		if len(value) != 1 {
+			return nil, fmt.Errorf("invalid lock in %q", line)
+		}
+		owner := s.locks[output]
+		switch videohub.Lock(value[0]) {
+		case videohub.Owned:
+			if owner != nil && owner != sess {
+				return nil, fmt.Errorf("output %d is locked", output)
+			}
+			owners[output] = sess
+		case videohub.Unlocked:
+			if owner != nil && owner != sess {
+				return nil, fmt.Errorf("output %d is locked", output)
+			}
+			owners[output] = nil
+		case videohub.Force:
+			owners[output] = nil
+		default:
+			return nil, fmt.Errorf("invalid lock in %q", line)
+		}
+	}
+
+	return func() {
+		for output, owner := range owners {
+			s.locks[output] = owner
+		}
+		for output := range owners {
+			s.broadcastLocks(output)
+		}
+	}, nil
+}
+
+func (s *Server) applyLabels(labels []string, header string, lines []string) (func(), error) {
+	changed := make(map[int]string)
+	for _, line := range lines {
+		i, value, err := s.parseIndexed(line, len(labels))
+		if err != nil {
+			return nil, err
+		}
+		changed[i] = value
+	}
+
+	return func() {
+		for i, label := range changed {
+			labels[i] = label
+		}
+		s.broadcast(func(*session) videohub.Block {
+			return indexed(header, changed)
+		})
+	}, nil
+}
+
+// parseIndexed splits a "<index> <value>" line and checks 0 <= index < n.
+func (s *Server) parseIndexed(line string, n int) (int, string, error) {
+	idx, value, ok := strings.Cut(line, " ")
+	i, err := strconv.Atoi(idx)
+	if !ok || err != nil || i < 0 || i >= n {
+		return 0, "", fmt.Errorf("invalid line %q", line)
+	}
+	return i, value, nil
+}
+
+// broadcast sends a block built per client to all clients. The caller must
+// hold s.mu.
+func (s *Server) broadcast(build func(*session) videohub.Block) {
+	for sess := range s.sessions {
+		sess.write(build(sess))
+	}
+}
+
+func (s *Server) broadcastLocks(output int) {
+	s.broadcast(func(sess *session) videohub.Block {
+		return indexed(videohub.VideoOutputLocks, map[int]string{output: string(s.lockFor(sess, output))})
+	})
+}
+
+// lockFor returns the lock state of output as seen by sess.
+func (s *Server) lockFor(sess *session, output int) videohub.Lock {
+	switch s.locks[output] {
+	case nil:
+		return videohub.Unlocked
+	case sess:
+		return videohub.Owned
+	}
+	return videohub.Locked
+}
+
+// prelude returns the status dump sent to a new client.
+func (s *Server) prelude(sess *session) []videohub.Block {
+	blocks := []videohub.Block{{
+		Header: videohub.ProtocolPreamble,
+		Lines:  []string{"Version: 2.8"},
+	}}
+	for _, header := range []string{
+		videohub.VideohubDevice,
+		videohub.InputLabels,
+		videohub.OutputLabels,
+		videohub.VideoOutputLocks,
+		videohub.VideoOutputRouting,
+	} {
+		b, _ := s.dump(sess, header)
+		blocks = append(blocks, b)
+	}
+	return append(blocks, videohub.Block{Header: videohub.EndPrelude})
+}
+
+// dump returns the full status block for header.
+func (s *Server) dump(sess *session, header string) (videohub.Block, bool) {
+	all := func(n int, value func(int) string) videohub.Block {
+		values := make(map[int]string, n)
+		for i := 0; i < n; i++ {
+			values[i] = value(i)
+		}
+		return indexed(header, values)
+	}
+
+	switch header {
+	case videohub.VideohubDevice:
+		return videohub.Block{Header: header, Lines: []string{
+			"Device present: true",
+			"Model name: " + s.model,
+			"Friendly name: Simulated Videohub",
+			"Unique ID: 000000000000",
+			fmt.Sprintf("Video inputs: %d", len(s.inputLabels)),
+			"Video processing units: 0",
+			fmt.Sprintf("Video outputs: %d", len(s.outputLabels)),
+			"Video monitoring outputs: 0",
+			"Serial ports: 0",
+		}}, true
+	case videohub.InputLabels:
+		return all(len(s.inputLabels), func(i int) string { return s.inputLabels[i] }), true
+	case videohub.OutputLabels:
+		return all(len(s.outputLabels), func(i int) string { return s.outputLabels[i] }), true
+	case videohub.VideoOutputLocks:
+		return all(len(s.locks), func(i int) string { return string(s.lockFor(sess, i)) }), true
+	case videohub.VideoOutputRouting:
+		return all(len(s.routing), func(i int) string { return strconv.Itoa(s.routing[i]) }), true
+	}
+	return videohub.Block{}, false
+}
+
+// indexed builds a block of "<index> <value>" lines sorted by index.
+func indexed(header string, values map[int]string) videohub.Block {
+	b := videohub.Block{Header: header}
+	for i := 0; len(b.Lines) < len(values); i++ {
+		if v, ok := values[i]; ok {
+			b.Lines = append(b.Lines, fmt.Sprintf("%d %s", i, v))
+		}
+	}
+	return b
+}
@@ -0,0 +1,382 @@
+// Package rawpanel implements a client for the SKAARHOJ Raw Panel ASCII protocol.
+package rawpanel
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned when sending on a client that has been closed.
+	ErrClosed = errors.New("rawpanel: client closed")
+
+	// ErrNotConnected is returned when sending while a reconnecting client is
+	// between connections. States sent meanwhile are replayed on reconnect.
+	ErrNotConnected = errors.New("rawpanel: not connected")
+)
+
+// ConnState is the connection state of a client.
+type ConnState int
+
+const (
+	Disconnected ConnState = iota
+	Connecting
+	Connected
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	}
+	return "unknown"
+}
+
+// Options configures a reconnecting client created with Connect.
+type Options struct {
+	// MinBackoff is the delay before the first reconnect attempt. It doubles
+	// on every failed attempt up to MaxBackoff. Defaults to 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// InitCommands are sent after "list" on every connect, before the
+	// remembered states are replayed, e.g. "Clear".
+	InitCommands []string
+
+	// OnStateChange, if set, is called from the connection goroutine
+	// whenever the connection state changes.
+	OnStateChange func(ConnState)
+
+	// OnTopology, if set, is called from the connection goroutine whenever
+	// the panel has reported its topology, i.e. after every connect.
+	OnTopology func(*Topology)
+
+	// OnError, if set, is called from the connection goroutine with the
+	// *SyntaxError of every malformed HWC event, which is then skipped.
+	OnError func(error)
+}
+
+// Client is a connection to a Raw Panel server.
+//
+// The client remembers the last state sent to every HWC. A client created
+// with Connect replays those states after every reconnect, so LEDs, texts
+// and images survive a panel reboot.
+type Client struct {
+	addr string
+	opts Options
+
+	events chan Event
+	done   chan struct{}
+
+	mu       sync.Mutex // guards the fields below and serializes writes
+	conn     net.Conn
+	closed   bool
+	states   map[int]*State
+	err      error
+	topo     *Topology // being collected from the current connection
+	topology *Topology // last complete topology
+}
+
+// Dial connects to the Raw Panel server at addr, e.g. "192.168.11.194:9923".
+// The returned client does not reconnect; its Events channel is closed when
+// the connection is lost.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient wraps an existing connection and starts reading from it.
+func NewClient(conn net.Conn) *Client {
+	c := newClient("", Options{})
+	c.conn = conn
+	c.topo = newTopology()
+	go func() {
+		defer close(c.events)
+		c.setErr(c.readLoop(conn))
+	}()
+	return c
+}
+
+// Connect returns a client that keeps a connection to addr open, retrying
+// with exponential backoff. After each (re)connect it sends "list" and
+// replays the last known state of every HWC. Its Events channel stays open
+// until Close is called.
+func Connect(addr string, opts Options) *Client {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 30 * time.Second
+	}
+
+	c := newClient(addr, opts)
+	go c.run()
+	return c
+}
+
+func newClient(addr string, opts Options) *Client {
+	return &Client{
+		addr:   addr,
+		opts:   opts,
+		events: make(chan Event, 64),
+		done:   make(chan struct{}),
+		states: make(map[int]*State),
+	}
+}
+
+// Handshake initializes the session by sending "list" and asking for the
+// panel topology. Clients created with Connect do this on their own.
+func (c *Client) Handshake() error {
+	if err := c.SendLine("list"); err != nil {
+		return err
+	}
+	return c.SendLine("PanelTopology?")
+}
+
+// Topology returns a copy of the last topology reported by the panel, or
+// nil if none has been received yet.
+func (c *Client) Topology() *Topology {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.topology == nil {
+		return nil
+	}
+	return c.topology.clone()
+}
+
+// Events returns the channel of incoming HWC events.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Err returns the error that ended the last connection, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Send encodes cmd as JSON and writes it as a single command line. A *State
+// is also remembered for replay after reconnecting.
+func (c *Client) Send(cmd interface{}) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	if s, ok := cmd.(*State); ok {
+		c.remember(s)
+	}
+	return c.SendLine(string(data))
+}
+
+// SendLine writes a raw command line such as "Clear". The trailing newline
+// is added if missing. "Clear" also forgets all remembered states.
+func (c *Client) SendLine(line string) error {
+	if strings.TrimSpace(line) == "Clear" {
+		c.mu.Lock()
+		c.states = make(map[int]*State)
+		c.mu.Unlock()
+	}
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	_, err := c.conn.Write([]byte(line))
+	return err
+}
+
+// Close closes the connection and stops reconnecting.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// remember merges s into the per-HWC state cache.
+func (c *Client) remember(s *State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range s.HWCIDs {
+		cached, ok := c.states[id]
+		if !ok {
+			cached = NewState(id)
+			c.states[id] = cached
+		}
+		cached.Merge(s)
+	}
+}
+
+// replay sends the handshake and the init commands followed by every
+// remembered state on conn. The caller must hold c.mu.
+func (c *Client) replay(conn net.Conn) error {
+	if _, err := conn.Write([]byte("list\nPanelTopology?\n")); err != nil {
+		return err
+	}
+	for _, line := range c.opts.InitCommands {
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+
+	ids := make([]int, 0, len(c.states))
+	for id := range c.states {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		data, err := json.Marshal(c.states[id])
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.events)
+
+	backoff := c.opts.MinBackoff
+	for {
+		c.setState(Connecting)
+		conn, err := net.Dial("tcp", c.addr)
+		if err == nil {
+			err = c.attach(conn)
+		}
+		if err == nil {
+			backoff = c.opts.MinBackoff
+			c.setState(Connected)
+			err = c.readLoop(conn)
+			c.detach(conn)
+			if err == nil {
+				err = io.EOF
+			}
+		}
+		c.setErr(err)
+		c.setState(Disconnected)
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// attach replays state on a fresh connection and makes it current.
+func (c *Client) attach(conn net.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return ErrClosed
+	}
+	if err := c.replay(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	c.conn = conn
+	c.topo = newTopology()
+	return nil
+}
+
+func (c *Client) detach(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	conn.Close()
+}
+
+func (c *Client) setState(state ConnState) {
+	if c.opts.OnStateChange != nil {
+		c.opts.OnStateChange(state)
+	}
+}
+
+func (c *Client) setErr(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+}
+
+func (c *Client) readLoop(conn net.Conn) error {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		ev, err := ParseEvent(scanner.Text())
+		if err == ErrNotHWC {
+			c.handleLine(scanner.Text())
+			continue
+		} else if err != nil {
+			// Malformed HWC events are reported and skipped
+			if c.opts.OnError != nil {
+				c.opts.OnError(err)
+			}
+			continue
+		}
+
+		select {
+		case c.events <- ev:
+		case <-c.done:
+			return ErrClosed
+		}
+	}
+	return scanner.Err()
+}
+
+// handleLine collects topology information from non-HWC lines.
+func (c *Client) handleLine(line string) {
+	c.mu.Lock()
+	complete, err := c.topo.parseTopologyLine(strings.TrimSpace(line))
+	if err != nil || !complete {
+		c.mu.Unlock()
+		return
+	}
+	c.topology = c.topo.clone()
+	topology := c.topo.clone()
+	c.mu.Unlock()
+
+	if c.opts.OnTopology != nil {
+		c.opts.OnTopology(topology)
+	}
+}
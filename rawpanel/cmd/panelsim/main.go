@@ -0,0 +1,114 @@
+// Command panelsim runs a simulated Raw Panel, so the tools can be tried
+// without a SKAARHOJ panel. Point a tool at it with -panel and type events
+// on stdin:
+//
+//	press <hwc> [edge]   button press, Down and Up
+//	down <hwc> [edge]    button down
+//	up <hwc> [edge]      button up
+//	turn <hwc> <pulses>  encoder, negative to turn left
+//	move <hwc> <0-1000>  fader position
+//	disconnect           drop the clients, as if the panel rebooted
+//
+// Lines starting with "HWC#" are sent as they are. Every command received
+// from a client is printed.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"rawpanel"
+	"rawpanel/panelsim"
+)
+
+func main() {
+	listen := flag.String("listen", ":9923", "address to listen on")
+	flag.Parse()
+
+	sim, err := panelsim.New(*listen, panelsim.Config{})
+	if err != nil {
+		fmt.Println("Error starting simulated panel:", err)
+		os.Exit(1)
+	}
+	defer sim.Close()
+	fmt.Println("Simulated panel listening on", sim.Addr())
+
+	go printReceived(sim)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := run(sim, strings.Fields(scanner.Text())); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
+// run executes one stdin command.
+func run(sim *panelsim.Server, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if strings.HasPrefix(args[0], "HWC#") {
+		return sim.SendLine(args[0])
+	}
+
+	nums := make([]int, len(args)-1)
+	for i, arg := range args[1:] {
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", arg)
+		}
+		nums[i] = n
+	}
+	arg := func(i int) int {
+		if i < len(nums) {
+			return nums[i]
+		}
+		return 0
+	}
+
+	switch {
+	case args[0] == "disconnect":
+		sim.Disconnect()
+		return nil
+	case len(nums) == 0:
+		return fmt.Errorf("missing HWC in %q", strings.Join(args, " "))
+	}
+
+	switch args[0] {
+	case "press":
+		return sim.PressEdge(nums[0], rawpanel.Edge(arg(1)))
+	case "down":
+		return sim.Emit(rawpanel.Event{HWC: nums[0], Edge: rawpanel.Edge(arg(1)), Trigger: rawpanel.TriggerDown})
+	case "up":
+		return sim.Emit(rawpanel.Event{HWC: nums[0], Edge: rawpanel.Edge(arg(1)), Trigger: rawpanel.TriggerUp})
+	case "turn":
+		return sim.Turn(nums[0], arg(1))
+	case "move":
+		return sim.Move(nums[0], arg(1))
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// printReceived prints the commands sent by clients as they arrive.
+func printReceived(sim *panelsim.Server) {
+	printed := 0
+	for {
+		sim.WaitFor(time.Hour, func() bool {
+			return len(sim.Received()) > printed
+		})
+		received := sim.Received()
+		if len(received) < printed {
+			printed = 0
+		}
+		for _, line := range received[printed:] {
+			fmt.Println("<", line)
+		}
+		printed = len(received)
+	}
+}
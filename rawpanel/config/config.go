@@ -0,0 +1,115 @@
+// Package config holds the helpers the tools use to load their settings from
+// a JSON file, environment variables and command line flags, in that order of
+// precedence (later wins).
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Load decodes the JSON file at path into v. Fields not present in the file
+// keep their current values, so v should hold the defaults. Unknown fields
+// are rejected to catch typos.
+func Load(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Override sets dst to the last non-empty value, e.g.
+//
+//	config.Override(&cfg.Panel, os.Getenv("MINI_PANEL"), *panelFlag)
+func Override(dst *string, values ...string) {
+	for _, v := range values {
+		if v != "" {
+			*dst = v
+		}
+	}
+}
+
+// OverrideInt is like Override for integer settings. Values that are not
+// valid integers are reported as errors.
+func OverrideInt(dst *int, name string, values ...string) error {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", name, v)
+		}
+		*dst = n
+	}
+	return nil
+}
+
+// CheckAddr validates a "host:port" TCP address.
+func CheckAddr(name, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("%s: address is required", name)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if host == "" {
+		return fmt.Errorf("%s: missing host in %q", name, addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s: invalid port in %q", name, addr)
+	}
+	return nil
+}
+
+// CheckURL validates an absolute http or https URL.
+func CheckURL(name, rawURL string) error {
+	if rawURL == "" {
+		return fmt.Errorf("%s: URL is required", name)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: %q is not an http(s) URL", name, rawURL)
+	}
+	return nil
+}
+
+// CheckHWCs validates that all named HWC IDs are positive and that no ID is
+// used twice.
+func CheckHWCs(hwcs map[string]int) error {
+	names := make([]string, 0, len(hwcs))
+	for name := range hwcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	used := make(map[int]string)
+	for _, name := range names {
+		id := hwcs[name]
+		if id < 1 {
+			return fmt.Errorf("%s: invalid HWC ID %d", name, id)
+		}
+		if other, ok := used[id]; ok {
+			return fmt.Errorf("%s: HWC ID %d is already used by %s", name, id, other)
+		}
+		used[id] = name
+	}
+	return nil
+}
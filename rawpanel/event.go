@@ -0,0 +1,175 @@
+package rawpanel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Trigger is the kind of action reported by an HWC event.
+type Trigger int
+
+const (
+	TriggerDown      Trigger = iota + 1 // Button pressed
+	TriggerUp                           // Button released
+	TriggerPress                        // Binary press without separate down/up
+	TriggerAbs                          // Absolute position, 0-1000 (faders, T-bars)
+	TriggerEnc                          // Relative encoder pulses, signed
+	TriggerSpeed                        // Speed, signed (joysticks, jog wheels)
+	TriggerIntensity                    // Intensity, e.g. pressure sensitive buttons
+)
+
+var triggerNames = map[Trigger]string{
+	TriggerDown:      "Down",
+	TriggerUp:        "Up",
+	TriggerPress:     "Press",
+	TriggerAbs:       "Abs",
+	TriggerEnc:       "Enc",
+	TriggerSpeed:     "Speed",
+	TriggerIntensity: "Intensity",
+}
+
+func (t Trigger) String() string {
+	if name, ok := triggerNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Trigger(%d)", int(t))
+}
+
+// Edge is the edge mask of a four-way button or encoder press, e.g. the 4 in
+// "HWC#1.4=Down". A zero Edge means the event had no edge suffix.
+type Edge int
+
+const (
+	EdgeTop     Edge = 1
+	EdgeLeft    Edge = 2
+	EdgeBottom  Edge = 4
+	EdgeRight   Edge = 8
+	EdgeEncoder Edge = 32
+)
+
+// Has reports whether all bits of mask are set.
+func (e Edge) Has(mask Edge) bool {
+	return e&mask == mask
+}
+
+// Event is a parsed "HWC#<id>[.<edge>]=<payload>" line.
+type Event struct {
+	HWC     int     // Hardware component ID
+	Edge    Edge    // Edge mask, 0 if not present
+	Trigger Trigger // What happened
+	Value   int     // Position, pulses, speed or intensity; 0 for button triggers
+	Line    string  // The line as received
+}
+
+// String formats the event as the panel sends it, e.g. "HWC#4.32=Enc:-1".
+func (e Event) String() string {
+	s := "HWC#" + strconv.Itoa(e.HWC)
+	if e.Edge != 0 {
+		s += "." + strconv.Itoa(int(e.Edge))
+	}
+	s += "=" + e.Trigger.String()
+	switch e.Trigger {
+	case TriggerDown, TriggerUp, TriggerPress:
+	default:
+		s += ":" + strconv.Itoa(e.Value)
+	}
+	return s
+}
+
+// ErrNotHWC is returned by ParseEvent for lines that are not HWC events.
+var ErrNotHWC = errors.New("rawpanel: not an HWC event")
+
+// SyntaxError describes a malformed HWC event line.
+type SyntaxError struct {
+	Line string
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("rawpanel: %s in %q", e.Msg, e.Line)
+}
+
+// ParseEvent parses an incoming HWC event line. Lines that do not start with
+// "HWC#" return ErrNotHWC; malformed HWC lines return a *SyntaxError.
+func ParseEvent(line string) (Event, error) {
+	line = strings.TrimSpace(line)
+	rest, ok := strings.CutPrefix(line, "HWC#")
+	if !ok {
+		return Event{}, ErrNotHWC
+	}
+	syntaxErr := func(format string, args ...interface{}) (Event, error) {
+		return Event{}, &SyntaxError{Line: line, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	id, payload, ok := strings.Cut(rest, "=")
+	if !ok {
+		return syntaxErr("missing '='")
+	}
+
+	ev := Event{Line: line}
+	idPart, edgePart, hasEdge := strings.Cut(id, ".")
+
+	hwc, err := parseUint(idPart)
+	if err != nil || hwc == 0 {
+		return syntaxErr("invalid HWC ID %q", idPart)
+	}
+	ev.HWC = hwc
+	if hasEdge {
+		edge, err := parseUint(edgePart)
+		if err != nil {
+			return syntaxErr("invalid edge %q", edgePart)
+		}
+		ev.Edge = Edge(edge)
+	}
+
+	name, value, hasValue := strings.Cut(payload, ":")
+	switch name {
+	case "Down":
+		ev.Trigger = TriggerDown
+	case "Up":
+		ev.Trigger = TriggerUp
+	case "Press":
+		ev.Trigger = TriggerPress
+	case "Abs":
+		ev.Trigger = TriggerAbs
+	case "Enc":
+		ev.Trigger = TriggerEnc
+	case "Speed":
+		ev.Trigger = TriggerSpeed
+	case "Intensity":
+		ev.Trigger = TriggerIntensity
+	default:
+		return syntaxErr("unknown trigger %q", name)
+	}
+
+	switch ev.Trigger {
+	case TriggerDown, TriggerUp, TriggerPress:
+		if hasValue {
+			return syntaxErr("unexpected value for %s", ev.Trigger)
+		}
+	default:
+		if !hasValue {
+			return syntaxErr("missing value for %s", ev.Trigger)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return syntaxErr("invalid %s value %q", ev.Trigger, value)
+		}
+		if ev.Trigger == TriggerAbs && (n < 0 || n > 1000) {
+			return syntaxErr("Abs value %d out of range 0-1000", n)
+		}
+		ev.Value = n
+	}
+
+	return ev, nil
+}
+
+// parseUint accepts plain decimal digits only, so "+1" or " 1" are rejected.
+func parseUint(s string) (int, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,111 @@
+package rawpanel
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		line string
+		want Event
+	}{
+		{"HWC#1=Down", Event{HWC: 1, Trigger: TriggerDown}},
+		{"HWC#12=Up", Event{HWC: 12, Trigger: TriggerUp}},
+		{"HWC#3=Press", Event{HWC: 3, Trigger: TriggerPress}},
+		{"HWC#5=Abs:0", Event{HWC: 5, Trigger: TriggerAbs}},
+		{"HWC#5=Abs:1000", Event{HWC: 5, Trigger: TriggerAbs, Value: 1000}},
+		{"HWC#4=Enc:-2", Event{HWC: 4, Trigger: TriggerEnc, Value: -2}},
+		{"HWC#4=Enc:3", Event{HWC: 4, Trigger: TriggerEnc, Value: 3}},
+		{"HWC#7=Speed:-500", Event{HWC: 7, Trigger: TriggerSpeed, Value: -500}},
+		{"HWC#8=Intensity:250", Event{HWC: 8, Trigger: TriggerIntensity, Value: 250}},
+		{"HWC#1.4=Down", Event{HWC: 1, Edge: EdgeBottom, Trigger: TriggerDown}},
+		{"HWC#1.15=Up", Event{HWC: 1, Edge: EdgeTop | EdgeLeft | EdgeBottom | EdgeRight, Trigger: TriggerUp}},
+		{"HWC#4.32=Enc:-1", Event{HWC: 4, Edge: EdgeEncoder, Trigger: TriggerEnc, Value: -1}},
+		{"  HWC#2=Down\r\n", Event{HWC: 2, Trigger: TriggerDown}},
+	}
+	for _, tt := range tests {
+		got, err := ParseEvent(tt.line)
+		if err != nil {
+			t.Errorf("ParseEvent(%q): %v", tt.line, err)
+			continue
+		}
+		got.Line = ""
+		if got != tt.want {
+			t.Errorf("ParseEvent(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestEdgeHas(t *testing.T) {
+	e := EdgeTop | EdgeEncoder
+	if !e.Has(EdgeTop) || !e.Has(EdgeEncoder) || !e.Has(EdgeTop|EdgeEncoder) {
+		t.Errorf("%d does not have its own bits", e)
+	}
+	if e.Has(EdgeLeft) || e.Has(EdgeTop|EdgeLeft) {
+		t.Errorf("%d has bits it does not contain", e)
+	}
+	if !e.Has(0) {
+		t.Errorf("%d does not have the empty mask", e)
+	}
+}
+
+func TestParseEventErrors(t *testing.T) {
+	tests := []string{
+		"HWC#1",           // Missing '='
+		"HWC#1Down",       // Missing '='
+		"HWC#0=Down",      // HWC 0
+		"HWC#=Down",       // No HWC
+		"HWC#+1=Down",     // Sign
+		"HWC# 1=Down",     // Space
+		"HWC#1.x=Down",    // Bad edge
+		"HWC#1.=Down",     // Empty edge
+		"HWC#1=Hold",      // Unknown trigger
+		"HWC#1=Abs:-1",    // Below range
+		"HWC#1=Abs:1001",  // Above range
+		"HWC#1=Abs:",      // Empty value
+		"HWC#1=Down:1",    // Value on Down
+		"HWC#1=Press:0",   // Value on Press
+		"HWC#1=Enc",       // Missing value
+		"HWC#1=Speed",     // Missing value
+		"HWC#1=Enc:one",   // Not a number
+		"HWC#1=Intensity", // Missing value
+	}
+	for _, line := range tests {
+		_, err := ParseEvent(line)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseEvent(%q): got error %v, want a *SyntaxError", line, err)
+		}
+	}
+
+	for _, line := range []string{"", "list", "_model=SK_MINI", "hwc#1=Down"} {
+		if _, err := ParseEvent(line); err != ErrNotHWC {
+			t.Errorf("ParseEvent(%q): got error %v, want ErrNotHWC", line, err)
+		}
+	}
+}
+
+func FuzzParseEvent(f *testing.F) {
+	for _, line := range []string{
+		"HWC#1=Down", "HWC#1.4=Up", "HWC#3=Press", "HWC#5=Abs:500",
+		"HWC#4.32=Enc:-1", "HWC#7=Speed:-20", "HWC#8=Intensity:3",
+		"HWC#0=Down", "HWC#1=Abs:1001", "HWC#1", "list",
+	} {
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		ev, err := ParseEvent(line)
+		if err != nil {
+			return
+		}
+		again, err := ParseEvent(ev.String())
+		if err != nil {
+			t.Fatalf("ParseEvent(%q) = %+v, but its String %q does not parse: %v", line, ev, ev.String(), err)
+		}
+		ev.Line, again.Line = "", ""
+		if again != ev {
+			t.Fatalf("ParseEvent(%q) = %+v, but its String %q parses as %+v", line, ev, ev.String(), again)
+		}
+	})
+}
@@ -0,0 +1,3 @@
+module rawpanel
+
+go 1.21.0
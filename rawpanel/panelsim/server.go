@@ -0,0 +1,400 @@
+// Package panelsim provides a fake Raw Panel server that answers "list" and
+// "PanelTopology?", sends scripted HWC events and records every command it
+// receives, for running the tools and their tests without a panel.
+package panelsim
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rawpanel"
+)
+
+var (
+	// ErrNoClient is returned when sending an event while no client is
+	// connected.
+	ErrNoClient = errors.New("panelsim: no client connected")
+
+	// ErrTimeout is returned by the Wait functions when the condition was
+	// not met in time.
+	ErrTimeout = errors.New("panelsim: timeout")
+)
+
+// HWC describes a component of the simulated panel.
+type HWC struct {
+	ID      int
+	Text    string
+	X, Y    int
+	Display *rawpanel.Display // nil for components without display
+}
+
+// Config sets up the simulated panel.
+type Config struct {
+	Model  string // Defaults to "SK_SIMULATOR"
+	Serial string // Defaults to "0000000000000"
+
+	// HWCs defaults to 48 components with IDs 1 to 48 and no displays.
+	HWCs []HWC
+}
+
+// Server is a simulated Raw Panel.
+type Server struct {
+	ln  net.Listener
+	cfg Config
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool
+	received []string
+	states   map[int]*rawpanel.State
+	changed  chan struct{} // Closed and replaced on every change
+	closed   bool
+}
+
+// New starts a simulated panel listening on addr, e.g. "127.0.0.1:0" for a
+// random port.
+func New(addr string, cfg Config) (*Server, error) {
+	if cfg.Model == "" {
+		cfg.Model = "SK_SIMULATOR"
+	}
+	if cfg.Serial == "" {
+		cfg.Serial = "0000000000000"
+	}
+	if len(cfg.HWCs) == 0 {
+		for id := 1; id <= 48; id++ {
+			cfg.HWCs = append(cfg.HWCs, HWC{ID: id, Text: fmt.Sprintf("HWC %d", id)})
+		}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ln:      ln,
+		cfg:     cfg,
+		conns:   make(map[net.Conn]bool),
+		states:  make(map[int]*rawpanel.State),
+		changed: make(chan struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the panel listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the panel and drops all clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return s.ln.Close()
+}
+
+// Disconnect drops all clients, as if the panel rebooted, but keeps
+// accepting new connections.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Clients returns the number of connected clients.
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Emit sends an HWC event to all clients.
+func (s *Server) Emit(ev rawpanel.Event) error {
+	return s.SendLine(ev.String())
+}
+
+// SendLine sends a raw line to all clients.
+func (s *Server) SendLine(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.conns) == 0 {
+		return ErrNoClient
+	}
+	for conn := range s.conns {
+		conn.Write([]byte(line + "\n"))
+	}
+	return nil
+}
+
+// Press sends a button press, Down followed by Up.
+func (s *Server) Press(hwc int) error {
+	return s.PressEdge(hwc, 0)
+}
+
+// PressEdge sends a press on one edge of a four-way button, e.g.
+// rawpanel.EdgeBottom, or on an encoder with rawpanel.EdgeEncoder.
+func (s *Server) PressEdge(hwc int, edge rawpanel.Edge) error {
+	if err := s.Emit(rawpanel.Event{HWC: hwc, Edge: edge, Trigger: rawpanel.TriggerDown}); err != nil {
+		return err
+	}
+	return s.Emit(rawpanel.Event{HWC: hwc, Edge: edge, Trigger: rawpanel.TriggerUp})
+}
+
+// Turn sends encoder pulses, negative to turn left.
+func (s *Server) Turn(hwc, pulses int) error {
+	return s.Emit(rawpanel.Event{HWC: hwc, Trigger: rawpanel.TriggerEnc, Value: pulses})
+}
+
+// Move sends an absolute fader or T-bar position, 0 to 1000.
+func (s *Server) Move(hwc, position int) error {
+	return s.Emit(rawpanel.Event{HWC: hwc, Trigger: rawpanel.TriggerAbs, Value: position})
+}
+
+// Received returns every line clients have sent, in order.
+func (s *Server) Received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// State returns the combined effect of all JSON commands sent to an HWC
+// since the last "Clear", or nil if there were none.
+func (s *Server) State(hwc int) *rawpanel.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[hwc]
+	if !ok {
+		return nil
+	}
+	c := rawpanel.NewState(hwc)
+	c.Merge(state)
+	return c
+}
+
+// Reset forgets the received lines and HWC states.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = nil
+	s.states = make(map[int]*rawpanel.State)
+}
+
+// WaitFor waits until cond returns true. cond is checked right away and
+// again whenever a client connects or sends a line, and may call the other
+// methods of s.
+func (s *Server) WaitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if cond() {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return ErrTimeout
+		}
+	}
+}
+
+// WaitConnected waits until a client has connected and sent "list".
+func (s *Server) WaitConnected(timeout time.Duration) error {
+	err := s.WaitFor(timeout, func() bool {
+		return s.Clients() > 0 && s.countLine("list") > 0
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for client: %w", err)
+	}
+	return nil
+}
+
+// WaitForLine waits until a client has sent line.
+func (s *Server) WaitForLine(timeout time.Duration, line string) error {
+	err := s.WaitFor(timeout, func() bool {
+		return s.countLine(line) > 0
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for %q: %w", line, err)
+	}
+	return nil
+}
+
+// WaitForState waits until the state of an HWC satisfies match.
+func (s *Server) WaitForState(timeout time.Duration, hwc int, match func(*rawpanel.State) bool) error {
+	err := s.WaitFor(timeout, func() bool {
+		state := s.State(hwc)
+		return state != nil && match(state)
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for state of HWC %d: %w", hwc, err)
+	}
+	return nil
+}
+
+func (s *Server) countLine(line string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, l := range s.received {
+		if l == line {
+			n++
+		}
+	}
+	return n
+}
+
+// notify wakes up waiters. The caller must hold s.mu.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.notify()
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.notify()
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, line)
+		reply := s.handle(line)
+		s.notify()
+		s.mu.Unlock()
+
+		for _, l := range reply {
+			conn.Write([]byte(l + "\n"))
+		}
+	}
+}
+
+// handle applies a command line and returns the panel's answer. The caller
+// must hold s.mu.
+func (s *Server) handle(line string) []string {
+	switch {
+	case line == "list":
+		return s.list()
+	case line == "PanelTopology?":
+		return []string{"_panelTopology_HWC=" + s.topologyJSON()}
+	case line == "ping":
+		return []string{"ack"}
+	case line == "Clear":
+		s.states = make(map[int]*rawpanel.State)
+	case strings.HasPrefix(line, "{"):
+		// Malformed JSON is only recorded, like the panel ignores it
+		var state rawpanel.State
+		if err := json.Unmarshal([]byte(line), &state); err != nil {
+			return nil
+		}
+		for _, id := range state.HWCIDs {
+			cached, ok := s.states[id]
+			if !ok {
+				cached = rawpanel.NewState(id)
+				s.states[id] = cached
+			}
+			cached.Merge(&state)
+		}
+	}
+	return nil
+}
+
+// list returns the answer to "list".
+func (s *Server) list() []string {
+	lines := []string{
+		"_model=" + s.cfg.Model,
+		"_serial=" + s.cfg.Serial,
+		"_version=v0.0.0",
+		"_name=Simulated Panel",
+		"_platform=panelsim",
+	}
+	for _, h := range s.cfg.HWCs {
+		lines = append(lines, fmt.Sprintf("map=%d:%d", h.ID, h.ID))
+	}
+	return lines
+}
+
+// topologyJSON returns the payload of "_panelTopology_HWC=". Every
+// component gets a type of its own, numbered by ID, carrying its display.
+func (s *Server) topologyJSON() string {
+	type disp struct {
+		W    int    `json:"w"`
+		H    int    `json:"h"`
+		Type string `json:"type,omitempty"`
+	}
+	type typ struct {
+		Disp *disp `json:"disp,omitempty"`
+	}
+	type hwc struct {
+		ID   int    `json:"id"`
+		X    int    `json:"x"`
+		Y    int    `json:"y"`
+		Txt  string `json:"txt"`
+		Type int    `json:"type"`
+	}
+	data := struct {
+		HWc       []hwc          `json:"HWc"`
+		TypeIndex map[string]typ `json:"typeIndex"`
+	}{TypeIndex: make(map[string]typ)}
+
+	hwcs := append([]HWC(nil), s.cfg.HWCs...)
+	sort.Slice(hwcs, func(i, j int) bool { return hwcs[i].ID < hwcs[j].ID })
+	for _, h := range hwcs {
+		data.HWc = append(data.HWc, hwc{ID: h.ID, X: h.X, Y: h.Y, Txt: h.Text, Type: h.ID})
+		t := typ{}
+		if h.Display != nil {
+			t.Disp = &disp{W: h.Display.W, H: h.Display.H, Type: h.Display.Type}
+		}
+		data.TypeIndex[strconv.Itoa(h.ID)] = t
+	}
+
+	b, _ := json.Marshal(data)
+	return string(b)
+}
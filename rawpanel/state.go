@@ -0,0 +1,177 @@
+package rawpanel
+
+// State is an outbound feedback command for one or more HWCs. Build it with
+// NewState and the chained setters, then pass it to Client.Send:
+//
+//	conn.Send(rawpanel.NewState(4, 5).Mode(rawpanel.StateOn).ColorIndex(rawpanel.ColorRed))
+type State struct {
+	HWCIDs      []int        `json:"HWCIDs"`
+	HWCMode     *HWCMode     `json:"HWCMode,omitempty"`
+	HWCColor    *HWCColor    `json:"HWCColor,omitempty"`
+	HWCExtended *HWCExtended `json:"HWCExtended,omitempty"`
+	HWCText     *HWCText     `json:"HWCText,omitempty"`
+	Processors  *Processors  `json:"Processors,omitempty"`
+}
+
+// HWCMode sets the LED state of a component.
+type HWCMode struct {
+	State        int  `json:"State"`
+	BlinkPattern int  `json:"BlinkPattern,omitempty"`
+	Output       bool `json:"Output,omitempty"`
+}
+
+// Values for HWCMode.State.
+const (
+	StateOff = 0
+	StateOn  = 4
+)
+
+// HWCColor sets the LED color, either from the panel palette or as RGB.
+type HWCColor struct {
+	ColorIndex *ColorIndex `json:"ColorIndex,omitempty"`
+	ColorRGB   *ColorRGB   `json:"ColorRGB,omitempty"`
+}
+
+// ColorIndex selects a color from the panel palette.
+type ColorIndex struct {
+	Index int `json:"Index"`
+}
+
+// Palette values for ColorIndex.
+const (
+	ColorDefault = iota
+	ColorOff
+	ColorWhite
+	ColorWarm
+	ColorRed
+	ColorRose
+	ColorPink
+	ColorPurple
+	ColorAmber
+	ColorYellow
+	ColorDarkBlue
+	ColorBlue
+	ColorIce
+	ColorCyan
+	ColorSpring
+	ColorGreen
+	ColorMint
+)
+
+// ColorRGB is an RGB color, 0-255 per channel.
+type ColorRGB struct {
+	Red   int `json:"Red"`
+	Green int `json:"Green"`
+	Blue  int `json:"Blue"`
+}
+
+// HWCExtended carries extended values such as motorized fader positions.
+type HWCExtended struct {
+	Interpretation int `json:"Interpretation"`
+	Value          int `json:"Value"`
+}
+
+// HWCText is the content of a component display.
+type HWCText struct {
+	IntegerValue   int    `json:"IntegerValue,omitempty"`
+	Formatting     int    `json:"Formatting,omitempty"`
+	Title          string `json:"Title,omitempty"`
+	SolidHeaderBar bool   `json:"SolidHeaderBar,omitempty"`
+	Textline1      string `json:"Textline1,omitempty"`
+	Textline2      string `json:"Textline2,omitempty"`
+	Inverted       bool   `json:"Inverted,omitempty"`
+}
+
+// Processors holds server side processing instructions, such as image
+// conversion.
+type Processors struct {
+	GfxConv *GfxConv `json:"GfxConv,omitempty"`
+}
+
+// GfxConv asks the panel to convert ImageData (a base64 encoded PNG or JPEG)
+// for display.
+type GfxConv struct {
+	W         int    `json:"W"`
+	H         int    `json:"H"`
+	Scaling   int    `json:"Scaling,omitempty"`
+	ImageType int    `json:"ImageType"`
+	ImageData string `json:"ImageData"`
+}
+
+// NewState returns a state addressed to the given HWC IDs.
+func NewState(hwcIDs ...int) *State {
+	return &State{HWCIDs: hwcIDs}
+}
+
+// Mode sets the LED state, e.g. StateOn.
+func (s *State) Mode(state int) *State {
+	if s.HWCMode == nil {
+		s.HWCMode = &HWCMode{}
+	}
+	s.HWCMode.State = state
+	return s
+}
+
+// Blink sets the blink pattern of the LED.
+func (s *State) Blink(pattern int) *State {
+	if s.HWCMode == nil {
+		s.HWCMode = &HWCMode{}
+	}
+	s.HWCMode.BlinkPattern = pattern
+	return s
+}
+
+// ColorIndex sets the LED color from the panel palette, e.g. ColorRed.
+func (s *State) ColorIndex(index int) *State {
+	s.HWCColor = &HWCColor{ColorIndex: &ColorIndex{Index: index}}
+	return s
+}
+
+// ColorRGB sets the LED color as RGB.
+func (s *State) ColorRGB(red, green, blue int) *State {
+	s.HWCColor = &HWCColor{ColorRGB: &ColorRGB{Red: red, Green: green, Blue: blue}}
+	return s
+}
+
+// Extended sets an extended value.
+func (s *State) Extended(interpretation, value int) *State {
+	s.HWCExtended = &HWCExtended{Interpretation: interpretation, Value: value}
+	return s
+}
+
+// Text sets the display content.
+func (s *State) Text(text HWCText) *State {
+	s.HWCText = &text
+	return s
+}
+
+// Image sets a graphics conversion for the display.
+func (s *State) Image(gfx GfxConv) *State {
+	s.Processors = &Processors{GfxConv: &gfx}
+	return s
+}
+
+// Merge copies the parts of other that are set into s, so s holds the
+// combined effect of both commands on an HWC.
+func (s *State) Merge(other *State) {
+	if other.HWCMode != nil {
+		mode := *other.HWCMode
+		s.HWCMode = &mode
+	}
+	if other.HWCColor != nil {
+		color := *other.HWCColor
+		s.HWCColor = &color
+	}
+	if other.HWCExtended != nil {
+		extended := *other.HWCExtended
+		s.HWCExtended = &extended
+	}
+	if other.HWCText != nil {
+		text := *other.HWCText
+		s.HWCText = &text
+	}
+	if other.Processors != nil {
+		processors := *other.Processors
+		s.Processors = &processors
+	}
+}
@@ -0,0 +1,189 @@
+package rawpanel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Topology describes the panel, as reported in response to "list" and
+// "PanelTopology?".
+type Topology struct {
+	Model    string
+	Serial   string
+	Version  string
+	Name     string
+	Platform string
+
+	// HWCs holds every component by ID. Components only reported through
+	// "map=" have no type or display information.
+	HWCs map[int]*HWC
+}
+
+// HWC is a hardware component of the panel.
+type HWC struct {
+	ID        int
+	Text      string   // Label printed next to the component
+	Type      int      // Index into the panel's type table
+	X, Y      int      // Position in the panel drawing
+	Display   *Display // nil if the component has no display
+	Available bool     // False if the panel maps the ID to nothing
+}
+
+// Display is the display of a component.
+type Display struct {
+	W, H int
+	Type string // "color", "gray" or empty for monochrome
+}
+
+// Color reports whether the display shows RGB colors.
+func (d *Display) Color() bool { return d.Type == "color" }
+
+// Gray reports whether the display shows shades of gray.
+func (d *Display) Gray() bool { return d.Type == "gray" }
+
+// Monochrome reports whether the display has 1-bit pixels.
+func (d *Display) Monochrome() bool { return !d.Color() && !d.Gray() }
+
+func newTopology() *Topology {
+	return &Topology{HWCs: make(map[int]*HWC)}
+}
+
+func (t *Topology) hwc(id int) *HWC {
+	h, ok := t.HWCs[id]
+	if !ok {
+		h = &HWC{ID: id, Available: true}
+		t.HWCs[id] = h
+	}
+	return h
+}
+
+// Display returns the display of an HWC, or nil if it has none or the
+// topology does not describe it.
+func (t *Topology) Display(id int) *Display {
+	if t == nil {
+		return nil
+	}
+	if hwc, ok := t.HWCs[id]; ok {
+		return hwc.Display
+	}
+	return nil
+}
+
+// DisplaySize returns the display resolution of an HWC.
+func (t *Topology) DisplaySize(id int) (w, h int, ok bool) {
+	d := t.Display(id)
+	if d == nil {
+		return 0, 0, false
+	}
+	return d.W, d.H, true
+}
+
+// CheckHWCs reports configured HWC IDs that the panel does not have. The
+// map keys name the settings, as for config.CheckHWCs.
+func (t *Topology) CheckHWCs(hwcs map[string]int) error {
+	names := make([]string, 0, len(hwcs))
+	for name := range hwcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		id := hwcs[name]
+		if h, ok := t.HWCs[id]; !ok || !h.Available {
+			errs = append(errs, fmt.Errorf("%s: HWC %d not found on panel %s", name, id, t.Model))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// topologyJSON is the payload of "_panelTopology_HWC=".
+type topologyJSON struct {
+	HWc []struct {
+		ID   int    `json:"id"`
+		X    int    `json:"x"`
+		Y    int    `json:"y"`
+		Txt  string `json:"txt"`
+		Type int    `json:"type"`
+	} `json:"HWc"`
+	TypeIndex map[string]struct {
+		Disp *struct {
+			W    int    `json:"w"`
+			H    int    `json:"h"`
+			Type string `json:"type"`
+		} `json:"disp"`
+	} `json:"typeIndex"`
+}
+
+// parseTopologyLine applies a non-HWC line from the panel to t. It reports
+// whether the line completed the topology, which is when the component
+// table arrives.
+func (t *Topology) parseTopologyLine(line string) (bool, error) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return false, nil
+	}
+
+	switch key {
+	case "_model":
+		t.Model = value
+	case "_serial":
+		t.Serial = value
+	case "_version":
+		t.Version = value
+	case "_name":
+		t.Name = value
+	case "_platform":
+		t.Platform = value
+	case "map":
+		// map=<hwc>:<target>, where target 0 means not available
+		from, to, ok := strings.Cut(value, ":")
+		if !ok {
+			return false, fmt.Errorf("rawpanel: invalid map %q", value)
+		}
+		id, err := strconv.Atoi(from)
+		if err != nil {
+			return false, fmt.Errorf("rawpanel: invalid map %q", value)
+		}
+		target, err := strconv.Atoi(to)
+		if err != nil {
+			return false, fmt.Errorf("rawpanel: invalid map %q", value)
+		}
+		t.hwc(id).Available = target != 0
+	case "_panelTopology_HWC":
+		var data topologyJSON
+		if err := json.Unmarshal([]byte(value), &data); err != nil {
+			return false, fmt.Errorf("rawpanel: invalid panel topology: %w", err)
+		}
+		for _, c := range data.HWc {
+			h := t.hwc(c.ID)
+			h.Text = c.Txt
+			h.Type = c.Type
+			h.X, h.Y = c.X, c.Y
+			if typ, ok := data.TypeIndex[strconv.Itoa(c.Type)]; ok && typ.Disp != nil {
+				h.Display = &Display{W: typ.Disp.W, H: typ.Disp.H, Type: typ.Disp.Type}
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// clone returns a deep copy of t.
+func (t *Topology) clone() *Topology {
+	c := *t
+	c.HWCs = make(map[int]*HWC, len(t.HWCs))
+	for id, h := range t.HWCs {
+		hc := *h
+		if h.Display != nil {
+			d := *h.Display
+			hc.Display = &d
+		}
+		c.HWCs[id] = &hc
+	}
+	return &c
+}